@@ -0,0 +1,1128 @@
+package handlertest
+
+import (
+	"bytes"
+	"compress/gzip"
+	"compress/zlib"
+	"crypto/md5"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"hash"
+	"io"
+	"io/ioutil"
+	"math"
+	"mime"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os/exec"
+	"reflect"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+	"unicode/utf8"
+)
+
+// exchange is a request as received by the handler, and the response it
+// recorded.
+type exchange struct {
+	req *http.Request
+	rec *httptest.ResponseRecorder
+	// body is the recorded body, after the transforms have been applied.
+	body []byte
+	// duration is the time it took to get the response.
+	duration time.Duration
+	// ignore is the body ignore function of the Runner, if any. See
+	// WithBodyIgnore.
+	ignore func(b []byte) []byte
+}
+
+// expectedBody passes a copy of exp, an expected body, through the body
+// ignore function, so that it compares to the body as normalized.
+func (ex *exchange) expectedBody(exp []byte) []byte {
+	if ex.ignore == nil {
+		return exp
+	}
+	return ex.ignore(append([]byte(nil), exp...))
+}
+
+// transform rewrites the body of the exchange before the assertions are
+// evaluated, e.g. to decode it.
+type transform func(ex *exchange, res *Response) error
+
+// transforms are applied by assertResponse in order.
+var transforms = []transform{
+	decompress,
+	decrypt,
+	stripANSI,
+}
+
+// assertion asserts one aspect of the exchange against the expected response.
+// Failures are reported on t.
+type assertion func(t tt, ex *exchange, res *Response)
+
+// assertions are evaluated by assertResponse in order.
+var assertions = []assertion{
+	assertCode,
+	assertNotCode,
+	assertRedirectTo,
+	assertBody,
+	assertBodyFile,
+	assertBodyBytes,
+	assertNotBody,
+	assertBodyRegexp,
+	assertBodySuffix,
+	assertBodyContains,
+	assertNotBodyContains,
+	assertEchoBody,
+	assertBodyForm,
+	assertBodyLength,
+	assertBodyLengthBaseline,
+	assertBodyChecksum,
+	assertHeaders,
+	assertNotHeaders,
+	assertContentType,
+	assertTrailers,
+	assertAllow,
+	assertCharset,
+	assertAccessControlMaxAge,
+	assertLastModified,
+	assertValidator,
+	assertByteRanges,
+	assertCookies,
+	assertProblem,
+	assertErrorEnvelope,
+	assertBodyValue,
+	assertBodyJSON,
+	assertJSONPaths,
+	assertJSONSchema,
+	assertBodyJSONArray,
+	assertBodySorted,
+	assertJSONArrayStream,
+	assertKeyOrder,
+	assertExpectStruct,
+	assertHandlerSawTrailers,
+	assertExpectQuery,
+	assertForbiddenBodyPatterns,
+	assertMaxDuration,
+	assertFunc,
+}
+
+// assertResponse evaluates all assertions against ex, and reports every
+// failure on t. If the Runner fails fast, evaluation stops after the first
+// assertion that failed.
+func (r *Runner) assertResponse(t tt, ex *exchange, res *Response) {
+	ft := failureT{tt: t}
+	if ex.rec.Body != nil {
+		ex.body = ex.rec.Body.Bytes()
+	}
+	for _, transform := range transforms {
+		if err := transform(ex, res); err != nil {
+			ft.Errorf("Cannot transform response body: %s", err)
+			if r.failFast {
+				return
+			}
+		}
+	}
+	if r.bodyIgnore != nil {
+		ex.ignore = r.bodyIgnore
+		ex.body = ex.expectedBody(ex.body)
+		if res.Body != "" {
+			res.Body = string(ex.expectedBody([]byte(res.Body)))
+		}
+	}
+	if res.BodyFile != "" && r.updating() {
+		if err := updateBodyFile(res.BodyFile, ex.body); err != nil {
+			ft.Errorf("Cannot update body file: %s", err)
+		} else {
+			t.Logf("handlertest: updated %s", res.BodyFile)
+		}
+		res.BodyFile = ""
+	}
+	for _, assert := range assertions {
+		assert(&ft, ex, res)
+		if r.failFast && ft.failed {
+			return
+		}
+	}
+}
+
+// failureT records whether a failure was reported on the tt it wraps.
+type failureT struct {
+	tt
+	failed bool
+}
+
+func (t *failureT) Errorf(format string, args ...interface{}) {
+	t.failed = true
+	t.tt.Errorf(format, args...)
+}
+
+func (t *failureT) Fatalf(format string, args ...interface{}) {
+	t.failed = true
+	t.tt.Fatalf(format, args...)
+}
+
+func (t *failureT) Run(name string, f func(t *testing.T)) bool {
+	ok := t.tt.Run(name, f)
+	if !ok {
+		t.failed = true
+	}
+	return ok
+}
+
+// prefixT prefixes every failure reported on the tt it wraps.
+type prefixT struct {
+	tt
+	prefix string
+}
+
+func (t prefixT) Errorf(format string, args ...interface{}) {
+	t.tt.Errorf(t.prefix+format, args...)
+}
+
+func (t prefixT) Fatalf(format string, args ...interface{}) {
+	t.tt.Fatalf(t.prefix+format, args...)
+}
+
+// decompress decodes the body according to its Content-Encoding. If the
+// request is known, the encoding must be acceptable according to its
+// Accept-Encoding header.
+func decompress(ex *exchange, res *Response) error {
+	if !res.Decompress {
+		return nil
+	}
+	ce := strings.ToLower(ex.rec.Result().Header.Get("Content-Encoding"))
+	if ex.req != nil {
+		if ae := ex.req.Header.Get("Accept-Encoding"); !acceptsEncoding(ae, ce) {
+			return fmt.Errorf("got Content-Encoding %q for Accept-Encoding %q", ce, ae)
+		}
+	}
+
+	var rc io.ReadCloser
+	var err error
+	switch ce {
+	case "", "identity":
+		return nil
+	case "gzip", "x-gzip":
+		rc, err = gzip.NewReader(bytes.NewReader(ex.body))
+	case "deflate":
+		rc, err = zlib.NewReader(bytes.NewReader(ex.body))
+	default:
+		return fmt.Errorf("unsupported Content-Encoding %q", ce)
+	}
+	if err != nil {
+		return err
+	}
+	defer func() {
+		_ = rc.Close()
+	}()
+	b, err := ioutil.ReadAll(rc)
+	if err != nil {
+		return err
+	}
+	ex.body = b
+	return nil
+}
+
+func decrypt(ex *exchange, res *Response) error {
+	if res.Decrypt == nil {
+		return nil
+	}
+	b, err := res.Decrypt(ex.body)
+	if err != nil {
+		return fmt.Errorf("decrypt: %s", err)
+	}
+	ex.body = b
+	return nil
+}
+
+// ansiEscape matches ANSI escape sequences: control sequences such as colors
+// (ESC [ ... m), operating system commands such as hyperlinks (ESC ] ... BEL)
+// and two-byte escapes.
+var ansiEscape = regexp.MustCompile(`\x1b(\[[0-?]*[ -/]*[@-~]|\][^\x07\x1b]*(\x07|\x1b\\)|[@-Z\\-_a-~])`)
+
+func stripANSI(ex *exchange, res *Response) error {
+	if res.StripANSI {
+		ex.body = ansiEscape.ReplaceAll(ex.body, nil)
+	}
+	return nil
+}
+
+// acceptsEncoding reports whether the Accept-Encoding header ae allows the
+// content coding ce. A coding listed explicitly takes precedence over "*".
+func acceptsEncoding(ae, ce string) bool {
+	if ce == "" || ce == "identity" {
+		return true
+	}
+	wildcard := false
+	for _, part := range strings.Split(ae, ",") {
+		params := strings.Split(part, ";")
+		coding := strings.ToLower(strings.TrimSpace(params[0]))
+		// A quality value of zero means "not acceptable".
+		q := 1.0
+		for _, p := range params[1:] {
+			kv := strings.SplitN(p, "=", 2)
+			if len(kv) == 2 && strings.ToLower(strings.TrimSpace(kv[0])) == "q" {
+				if f, err := strconv.ParseFloat(strings.TrimSpace(kv[1]), 64); err == nil {
+					q = f
+				}
+			}
+		}
+		switch coding {
+		case ce:
+			return q > 0
+		case "*":
+			wildcard = q > 0
+		}
+	}
+	return wildcard
+}
+
+func assertCode(t tt, ex *exchange, res *Response) {
+	if res.CodeClass != "" {
+		class, ok := parseCodeClass(res.CodeClass)
+		switch {
+		case !ok:
+			t.Errorf("Invalid code class %q, expected one of 1xx through 5xx", res.CodeClass)
+		case ex.rec.Code/100 != class:
+			t.Errorf("Got response code %d, expected %s", ex.rec.Code, res.CodeClass)
+		}
+	}
+	if len(res.Codes) > 0 && !containsInt(res.Codes, ex.rec.Code) {
+		t.Errorf("Got response code %d, expected one of %v", ex.rec.Code, res.Codes)
+	}
+	expCode := res.Code
+	if isZero(expCode) {
+		if res.CodeAny || res.NotCode != 0 || res.CodeClass != "" || len(res.Codes) > 0 || res.RedirectTo != "" {
+			return
+		}
+		expCode = http.StatusOK
+	}
+	if ex.rec.Code != expCode {
+		t.Errorf("Got response code %d, expected %d", ex.rec.Code, expCode)
+	}
+}
+
+func assertAllow(t tt, ex *exchange, res *Response) {
+	if res.Allow == nil && res.Code != http.StatusMethodNotAllowed {
+		return
+	}
+	hdr := strings.Join(ex.rec.Result().Header["Allow"], ",")
+	var methods []string
+	for _, m := range strings.Split(hdr, ",") {
+		if m = strings.TrimSpace(m); m != "" {
+			methods = append(methods, strings.ToUpper(m))
+		}
+	}
+	if len(methods) == 0 {
+		t.Errorf("Missing response header Allow, expected it to list the allowed methods")
+		return
+	}
+	if res.Allow == nil {
+		return
+	}
+
+	exp := make([]string, 0, len(res.Allow))
+	for _, m := range res.Allow {
+		exp = append(exp, strings.ToUpper(m))
+	}
+	sort.Strings(exp)
+	sort.Strings(methods)
+	if !reflect.DeepEqual(methods, exp) {
+		t.Errorf("Got Allow %q, expected methods %q", hdr, exp)
+	}
+}
+
+// parseCodeClass returns the leading digit of a status code class such as
+// "2xx", and whether s is one.
+func parseCodeClass(s string) (int, bool) {
+	if len(s) != 3 || s[0] < '1' || s[0] > '5' || !strings.EqualFold(s[1:], "xx") {
+		return 0, false
+	}
+	return int(s[0] - '0'), true
+}
+
+func assertNotCode(t tt, ex *exchange, res *Response) {
+	if res.NotCode != 0 && ex.rec.Code == res.NotCode {
+		t.Errorf("Got response code %d, expected any other", ex.rec.Code)
+	}
+}
+
+func assertRedirectTo(t tt, ex *exchange, res *Response) {
+	if res.RedirectTo == "" {
+		return
+	}
+	if ex.rec.Code/100 != 3 {
+		t.Errorf("Got response code %d, expected a redirect to %s", ex.rec.Code, res.RedirectTo)
+		return
+	}
+	loc := ex.rec.Result().Header.Get("Location")
+	if loc == "" {
+		t.Errorf("Missing Location header, expected %s", res.RedirectTo)
+		return
+	}
+	base := &url.URL{Path: "/"}
+	if ex.req != nil {
+		base = &url.URL{Scheme: "http", Host: ex.req.Host, Path: ex.req.URL.Path}
+	}
+	act, err := base.Parse(loc)
+	if err != nil {
+		t.Errorf("Got invalid Location header %q: %s", loc, err)
+		return
+	}
+	exp, err := base.Parse(res.RedirectTo)
+	if err != nil {
+		t.Errorf("Invalid RedirectTo %q: %s", res.RedirectTo, err)
+		return
+	}
+	if act.String() != exp.String() {
+		t.Errorf("Got Location %q, expected a redirect to %s", loc, res.RedirectTo)
+	}
+}
+
+func assertBody(t tt, ex *exchange, res *Response) {
+	body, expBody := res.normalizeBodies(string(ex.body), res.Body)
+	if !isZero(expBody) && body != expBody {
+		t.Errorf("Got response body %q, expected %q", body, expBody)
+	}
+	if res.EmptyBody && body != "" {
+		t.Errorf("Got response body %q, expected it to be empty", body)
+	}
+}
+
+func assertBodyContains(t tt, ex *exchange, res *Response) {
+	for _, frag := range res.BodyContains {
+		body, frag := res.normalizeBodies(string(ex.body), frag)
+		if !strings.Contains(body, frag) {
+			t.Errorf("Got response body %q, expected it to contain %q", body, frag)
+		}
+	}
+}
+
+func assertBodyBytes(t tt, ex *exchange, res *Response) {
+	for _, enc := range []struct {
+		name    string
+		encoded string
+		decode  func(string) ([]byte, error)
+	}{
+		{"BodyBase64", res.BodyBase64, base64.StdEncoding.DecodeString},
+		{"BodyHex", res.BodyHex, hex.DecodeString},
+	} {
+		if enc.encoded == "" {
+			continue
+		}
+		exp, err := enc.decode(strings.Join(strings.Fields(enc.encoded), ""))
+		if err != nil {
+			t.Errorf("Invalid %s: %s", enc.name, err)
+			continue
+		}
+		exp = ex.expectedBody(exp)
+		if i := firstDifference(ex.body, exp); i >= 0 {
+			t.Errorf("Got response body of %d bytes differing from %s at byte %d, expected %d bytes", len(ex.body), enc.name, i, len(exp))
+		}
+	}
+}
+
+// firstDifference returns the offset of the first byte in which a and b
+// differ, or -1 if these are equal.
+func firstDifference(a, b []byte) int {
+	for i := 0; i < len(a) && i < len(b); i++ {
+		if a[i] != b[i] {
+			return i
+		}
+	}
+	if len(a) != len(b) {
+		if len(a) < len(b) {
+			return len(a)
+		}
+		return len(b)
+	}
+	return -1
+}
+
+func assertNotBody(t tt, ex *exchange, res *Response) {
+	if res.NotBody == "" {
+		return
+	}
+	if body, notBody := res.normalizeBodies(string(ex.body), res.NotBody); body == notBody {
+		t.Errorf("Got response body %q, expected any other", notBody)
+	}
+}
+
+func assertNotBodyContains(t tt, ex *exchange, res *Response) {
+	for _, frag := range res.NotBodyContains {
+		body, frag := res.normalizeBodies(string(ex.body), frag)
+		if i := strings.Index(body, frag); i >= 0 {
+			t.Errorf("Got response body containing %q, expected it not to: %q", frag, snippet([]byte(body), i, i+len(frag)))
+		}
+	}
+}
+
+func assertBodyRegexp(t tt, ex *exchange, res *Response) {
+	if res.BodyRegexp == "" {
+		return
+	}
+	re, err := regexp.Compile(res.BodyRegexp)
+	if err != nil {
+		t.Errorf("Invalid body regular expression %q: %s", res.BodyRegexp, err)
+		return
+	}
+	m := re.FindSubmatch(ex.body)
+	if m == nil {
+		t.Errorf("Got response body %q, expected it to match %q", string(ex.body), res.BodyRegexp)
+		return
+	}
+
+	groups := make(map[string]string)
+	for i, name := range re.SubexpNames() {
+		if name != "" {
+			groups[name] = string(m[i])
+		}
+	}
+	names := make([]string, 0, len(res.BodyRegexpGroups))
+	for name := range res.BodyRegexpGroups {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range append(names, res.BodyRegexpNonEmpty...) {
+		act, ok := groups[name]
+		exp, pinned := res.BodyRegexpGroups[name]
+		switch {
+		case !ok:
+			t.Errorf("Invalid body regular expression group %q: not in %q", name, res.BodyRegexp)
+		case pinned && act != exp:
+			t.Errorf("Got body regular expression group %q %q, expected %q", name, act, exp)
+		case !pinned && act == "":
+			t.Errorf("Got empty body regular expression group %q, expected a value", name)
+		}
+	}
+}
+
+func assertBodySuffix(t tt, ex *exchange, res *Response) {
+	if res.ExpectBodySuffix == "" {
+		return
+	}
+	body, suffix := res.normalizeBodies(string(ex.body), res.ExpectBodySuffix)
+	if strings.HasSuffix(body, suffix) {
+		return
+	}
+	tail := body
+	if n := len(suffix) + snippetContext; len(tail) > n {
+		tail = tail[len(tail)-n:]
+	}
+	t.Errorf("Got response body ending in %q, expected it to end with %q", tail, suffix)
+}
+
+func assertEchoBody(t tt, ex *exchange, res *Response) {
+	if res.EchoBody == nil {
+		return
+	}
+	if ex.req == nil {
+		t.Errorf("Cannot assert echoed body: handler was not called")
+		return
+	}
+	if body, expBody := string(ex.body), res.EchoBody(ex.req); body != expBody {
+		t.Errorf("Got response body not echoing the request: %s", diffText(body, expBody))
+	}
+}
+
+func assertBodyForm(t tt, ex *exchange, res *Response) {
+	if res.BodyForm == nil {
+		return
+	}
+	form, err := url.ParseQuery(string(ex.body))
+	if err != nil {
+		t.Errorf("Got response body %q, expected it to be form-encoded: %s", string(ex.body), err)
+		return
+	}
+	keys := make([]string, 0, len(form)+len(res.BodyForm))
+	for k := range form {
+		keys = append(keys, k)
+	}
+	for k := range res.BodyForm {
+		if _, ok := form[k]; !ok {
+			keys = append(keys, k)
+		}
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		exp, eok := res.BodyForm[k]
+		act, aok := form[k]
+		switch {
+		case !aok:
+			t.Errorf("Missing form key %q in response body, expected %q", k, exp)
+		case !eok:
+			t.Errorf("Got unexpected form key %q with %q in response body", k, act)
+		case len(act) != 1 || act[0] != exp:
+			t.Errorf("Got form key %q with %q in response body, expected %q", k, act, exp)
+		}
+	}
+}
+
+func assertBodyLength(t tt, ex *exchange, res *Response) {
+	n := len(ex.body)
+	if res.BodyLength != 0 && n != res.BodyLength {
+		t.Errorf("Got response body of %d bytes, expected %d", n, res.BodyLength)
+	}
+	if res.MaxBodyBytes != 0 && n > res.MaxBodyBytes {
+		t.Errorf("Got response body of %d bytes, expected at most %d", n, res.MaxBodyBytes)
+	}
+}
+
+func assertBodyLengthBaseline(t tt, ex *exchange, res *Response) {
+	if res.BodyLengthBaseline == 0 {
+		return
+	}
+	base, tol := float64(res.BodyLengthBaseline), math.Abs(res.BodyLengthTolerance)
+	min, max := int(math.Ceil(base*(1-tol))), int(math.Floor(base*(1+tol)))
+	if n := len(ex.body); n < min || n > max {
+		t.Errorf("Got response body of %d bytes, expected between %d and %d (%d ± %.0f%%)",
+			n, min, max, res.BodyLengthBaseline, tol*100)
+	}
+}
+
+// checksums are the hash algorithms supported by Response.BodyChecksum.
+var checksums = map[string]func() hash.Hash{
+	"md5":    md5.New,
+	"sha1":   sha1.New,
+	"sha256": sha256.New,
+	"sha512": sha512.New,
+}
+
+func assertBodyChecksum(t tt, ex *exchange, res *Response) {
+	if res.BodyChecksum != "" {
+		checkChecksum(t, ex.body, res.BodyChecksum)
+	}
+	if res.BodySHA256 != "" {
+		checkChecksum(t, ex.body, "sha256:"+res.BodySHA256)
+	}
+}
+
+// checkChecksum asserts b has the digest checksum, as in BodyChecksum.
+func checkChecksum(t tt, b []byte, checksum string) {
+	algo, exp := "sha256", checksum
+	if i := strings.Index(exp, ":"); i >= 0 {
+		algo, exp = strings.ToLower(exp[:i]), exp[i+1:]
+	}
+	newHash, ok := checksums[algo]
+	if !ok {
+		t.Errorf("Invalid body checksum %q: unsupported algorithm %q", checksum, algo)
+		return
+	}
+	h := newHash()
+	_, _ = h.Write(b)
+	if act := hex.EncodeToString(h.Sum(nil)); act != strings.ToLower(exp) {
+		t.Errorf("Got response body with checksum %s:%s, expected %s:%s", algo, act, algo, exp)
+	}
+}
+
+func assertHeaders(t tt, ex *exchange, res *Response) {
+	checkHeaders(t, "header", ex.rec.Result().Header, res.Headers)
+}
+
+func assertNotHeaders(t tt, ex *exchange, res *Response) {
+	hdr := ex.rec.Result().Header
+	for _, name := range res.NotHeaders {
+		if values, ok := hdr[http.CanonicalHeaderKey(name)]; ok {
+			t.Errorf("Got response header %s %q, expected it to be absent", name, strings.Join(values, ", "))
+		}
+	}
+}
+
+func assertContentType(t tt, ex *exchange, res *Response) {
+	if res.ContentType == "" {
+		return
+	}
+	ct := ex.rec.Result().Header.Get("Content-Type")
+	mt, _, err := mime.ParseMediaType(ct)
+	if err != nil {
+		t.Errorf("Got invalid Content-Type %q, expected media type %s: %s", ct, res.ContentType, err)
+		return
+	}
+	if !strings.EqualFold(mt, res.ContentType) {
+		t.Errorf("Got Content-Type %q, expected media type %s", ct, res.ContentType)
+	}
+}
+
+func assertTrailers(t tt, ex *exchange, res *Response) {
+	checkHeaders(t, "trailer", ex.rec.Result().Trailer, res.Trailers)
+}
+
+// checkHeaders asserts hdr holds the expected fields, in the `Key: Value`
+// format of Response.Headers. kind names the fields in failures.
+func checkHeaders(t tt, kind string, hdr http.Header, expected []string) {
+	for _, h := range expected {
+		split := strings.SplitN(h, ": ", 2)
+		if _, ok := hdr[http.CanonicalHeaderKey(split[0])]; !ok {
+			t.Errorf("Missing response %s %q", kind, split[0])
+			continue
+		}
+		if len(split) < 2 {
+			continue
+		}
+		values := hdr[http.CanonicalHeaderKey(split[0])]
+		switch {
+		case containsString(values, split[1]):
+		case len(values) == 1:
+			t.Errorf("Got response %s %s %q, expected %q", kind, split[0], values[0], split[1])
+		default:
+			t.Errorf("Got response %s %s with values %q, expected one to be %q", kind, split[0], values, split[1])
+		}
+	}
+}
+
+// containsInt reports whether is contains i.
+func containsInt(is []int, i int) bool {
+	for _, v := range is {
+		if v == i {
+			return true
+		}
+	}
+	return false
+}
+
+// containsString reports whether ss contains s.
+func containsString(ss []string, s string) bool {
+	for _, v := range ss {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
+
+func assertCharset(t tt, ex *exchange, res *Response) {
+	if !res.CheckCharset {
+		return
+	}
+	if err := checkCharset(ex.rec.Result().Header.Get("Content-Type"), ex.body); err != nil {
+		t.Errorf("Got response body not matching charset: %s", err)
+	}
+}
+
+func assertAccessControlMaxAge(t tt, ex *exchange, res *Response) {
+	if res.AccessControlMaxAge == nil {
+		return
+	}
+	v := ex.rec.Result().Header.Get("Access-Control-Max-Age")
+	if v == "" {
+		t.Errorf("Missing response header %q", "Access-Control-Max-Age")
+		return
+	}
+	maxAge, err := strconv.Atoi(v)
+	if err != nil {
+		t.Errorf("Got invalid Access-Control-Max-Age %q: %s", v, err)
+		return
+	}
+	if b := res.AccessControlMaxAge; !b.contains(maxAge) {
+		t.Errorf("Got Access-Control-Max-Age %d, expected between %d and %d", maxAge, b.Min, b.Max)
+	}
+}
+
+func assertLastModified(t tt, ex *exchange, res *Response) {
+	if !res.CheckLastModified && !res.LastModifiedNotFuture {
+		return
+	}
+	lm := ex.rec.Result().Header.Get("Last-Modified")
+	if lm == "" {
+		t.Errorf("Missing response header %q", "Last-Modified")
+		return
+	}
+	mod, err := http.ParseTime(lm)
+	if err != nil {
+		t.Errorf("Got invalid Last-Modified %q: %s", lm, err)
+		return
+	}
+	// HTTP dates have a resolution of one second.
+	if now := time.Now().Truncate(time.Second); res.LastModifiedNotFuture && mod.After(now) {
+		t.Errorf("Got Last-Modified %q, expected it not to be in the future", lm)
+	}
+}
+
+func assertValidator(t tt, ex *exchange, res *Response) {
+	if len(res.Validator) == 0 {
+		return
+	}
+	var stderr bytes.Buffer
+	cmd := exec.Command(res.Validator[0], res.Validator[1:]...)
+	cmd.Stdin = bytes.NewReader(ex.body)
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		t.Errorf("Validator %q rejected response body (%s): %s", res.Validator, err, strings.TrimSpace(stderr.String()))
+	}
+}
+
+func assertErrorEnvelope(t tt, ex *exchange, res *Response) {
+	if res.ErrorEnvelope == nil {
+		return
+	}
+	var envelope map[string]interface{}
+	if err := json.Unmarshal(ex.body, &envelope); err != nil {
+		t.Errorf("Got response body %q, expected a JSON error envelope: %s", string(ex.body), err)
+		return
+	}
+	for _, k := range res.ErrorEnvelope {
+		if _, ok := envelope[k]; !ok {
+			t.Errorf("Missing key %q in JSON error envelope %s", k, string(ex.body))
+		}
+	}
+}
+
+func assertBodyJSON(t tt, ex *exchange, res *Response) {
+	if res.BodyJSON == "" {
+		return
+	}
+	exp, err := decodeJSON([]byte(res.BodyJSON))
+	if err != nil {
+		t.Errorf("Invalid expected JSON body: %s", err)
+		return
+	}
+	act, err := decodeJSON(ex.body)
+	if err != nil {
+		t.Errorf("Got invalid JSON response body: %s", err)
+		return
+	}
+	if err := removeJSON(exp, res.JSONIgnoreFields); err != nil {
+		t.Errorf("Invalid ignored JSON field: %s", err)
+		return
+	}
+	_ = removeJSON(act, res.JSONIgnoreFields)
+	d := res.jsonDiffer()
+	for _, diff := range d.diff(exp, act, nil) {
+		t.Errorf("Got unexpected JSON response body: %s", diff)
+	}
+}
+
+func assertJSONPaths(t tt, ex *exchange, res *Response) {
+	if len(res.JSONPaths) == 0 && len(res.JSONPathsExist) == 0 {
+		return
+	}
+	v, err := decodeJSON(ex.body)
+	if err != nil {
+		t.Errorf("Got invalid JSON response body: %s", err)
+		return
+	}
+
+	paths := make([]string, 0, len(res.JSONPaths))
+	for path := range res.JSONPaths {
+		paths = append(paths, path)
+	}
+	sort.Strings(paths)
+	d := res.jsonDiffer()
+	for _, path := range paths {
+		exp, err := normalizeJSON(res.JSONPaths[path])
+		if err != nil {
+			t.Errorf("Invalid expected value for JSON path %q: %s", path, err)
+			continue
+		}
+		segments, err := parsePath(path)
+		if err != nil {
+			t.Errorf("Invalid JSON path %q: %s", path, err)
+			continue
+		}
+		act, err := lookupJSON(v, path)
+		if err != nil {
+			t.Errorf("Got JSON response body without %s: %s", path, err)
+			continue
+		}
+		for _, diff := range d.diff(exp, act, segments) {
+			t.Errorf("Got unexpected JSON response body: %s", diff)
+		}
+	}
+	for _, path := range res.JSONPathsExist {
+		if _, err := parsePath(path); err != nil {
+			t.Errorf("Invalid JSON path %q: %s", path, err)
+			continue
+		}
+		if _, err := lookupJSON(v, path); err != nil {
+			t.Errorf("Got JSON response body without %s: %s", path, err)
+		}
+	}
+}
+
+func assertBodyJSONArray(t tt, ex *exchange, res *Response) {
+	if res.BodyJSONArray == nil {
+		return
+	}
+	exp, err := normalizeJSON(res.BodyJSONArray)
+	if err != nil {
+		t.Errorf("Invalid expected JSON array: %s", err)
+		return
+	}
+	act, err := decodeJSON(ex.body)
+	if err != nil {
+		t.Errorf("Got invalid JSON response body: %s", err)
+		return
+	}
+	d := res.jsonDiffer()
+	d.subset = true
+	for _, diff := range d.diff(exp, act, nil) {
+		t.Errorf("Got unexpected JSON array element: %s", diff)
+	}
+}
+
+func assertBodySorted(t tt, ex *exchange, res *Response) {
+	if res.BodySorted == nil {
+		return
+	}
+	if err := checkSorted(ex.body, res.BodySorted); err != nil {
+		t.Errorf("Got unsorted response body: %s", err)
+	}
+}
+
+func assertKeyOrder(t tt, ex *exchange, res *Response) {
+	paths := make([]string, 0, len(res.ExpectKeyOrder))
+	for p := range res.ExpectKeyOrder {
+		paths = append(paths, p)
+	}
+	sort.Strings(paths)
+	for _, p := range paths {
+		keys, err := objectKeys(ex.body, p)
+		if err != nil {
+			t.Errorf("Cannot read key order of JSON object at %q: %s", p, err)
+			continue
+		}
+		// Report the first key that is out of order.
+		exp := res.ExpectKeyOrder[p]
+		i := 0
+		for i < len(keys) && i < len(exp) && keys[i] == exp[i] {
+			i++
+		}
+		switch {
+		case i < len(keys) && i < len(exp):
+			t.Errorf("Got key %q at position %d of JSON object at %q, expected %q (got keys %q)", keys[i], i, p, exp[i], keys)
+		case i < len(exp):
+			t.Errorf("Missing key %q at position %d of JSON object at %q, got keys %q", exp[i], i, p, keys)
+		case i < len(keys):
+			t.Errorf("Got unexpected key %q at position %d of JSON object at %q, expected keys %q", keys[i], i, p, exp)
+		}
+	}
+}
+
+func assertForbiddenBodyPatterns(t tt, ex *exchange, res *Response) {
+	if len(res.ForbiddenBodyPatterns) == 0 || ex.rec.Code < http.StatusBadRequest {
+		return
+	}
+	for _, p := range res.ForbiddenBodyPatterns {
+		re, err := regexp.Compile(p)
+		if err != nil {
+			t.Errorf("Invalid forbidden body pattern %q: %s", p, err)
+			continue
+		}
+		if loc := re.FindIndex(ex.body); loc != nil {
+			t.Errorf("Got error response body matching forbidden pattern %q: %q", p, snippet(ex.body, loc[0], loc[1]))
+		}
+	}
+}
+
+// snippetContext is the number of bytes around a match that snippet includes.
+const snippetContext = 20
+
+// snippet returns b[start:end], along with up to snippetContext bytes on
+// either side of it, marking truncation with an ellipsis.
+func snippet(b []byte, start, end int) string {
+	from, to := start-snippetContext, end+snippetContext
+	prefix, suffix := "...", "..."
+	if from <= 0 {
+		from, prefix = 0, ""
+	}
+	if to >= len(b) {
+		to, suffix = len(b), ""
+	}
+	return prefix + string(b[from:to]) + suffix
+}
+
+func assertExpectQuery(t tt, ex *exchange, res *Response) {
+	if len(res.ExpectQuery) == 0 {
+		return
+	}
+	if ex.req == nil {
+		t.Errorf("Cannot assert query parameters: handler was not called")
+		return
+	}
+	q := ex.req.URL.Query()
+	keys := make([]string, 0, len(res.ExpectQuery))
+	for k := range res.ExpectQuery {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		if exp, act := res.ExpectQuery[k], q[k]; !reflect.DeepEqual(act, exp) {
+			t.Errorf("Handler saw query parameter %q %q, expected %q", k, act, exp)
+		}
+	}
+}
+
+func assertHandlerSawTrailers(t tt, ex *exchange, res *Response) {
+	if len(res.ExpectHandlerSawTrailers) == 0 {
+		return
+	}
+	if ex.req == nil {
+		t.Errorf("Cannot assert request trailers: handler was not called")
+		return
+	}
+	keys := make([]string, 0, len(res.ExpectHandlerSawTrailers))
+	for k := range res.ExpectHandlerSawTrailers {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		exp, act := res.ExpectHandlerSawTrailers[k], ex.req.Trailer.Get(k)
+		switch {
+		case act == "":
+			t.Errorf("Handler did not see request trailer %s, expected %q", k, exp)
+		case act != exp:
+			t.Errorf("Handler saw request trailer %s %q, expected %q", k, act, exp)
+		}
+	}
+}
+
+func assertFunc(t tt, ex *exchange, res *Response) {
+	if res.Assert == nil {
+		return
+	}
+	resp := ex.rec.Result()
+	resp.Body = ioutil.NopCloser(bytes.NewReader(ex.body))
+	resp.ContentLength = int64(len(ex.body))
+	resp.Request = ex.req
+	t.Run("Assert", func(t *testing.T) {
+		res.Assert(t, resp)
+	})
+}
+
+// checkSorted verifies that the JSON array in b described by bs is sorted.
+func checkSorted(b []byte, bs *BodySorted) error {
+	var desc bool
+	switch strings.ToLower(bs.Order) {
+	case "", "asc":
+	case "desc":
+		desc = true
+	default:
+		return fmt.Errorf("invalid order %q (expected asc or desc)", bs.Order)
+	}
+
+	v, err := decodeJSON(b)
+	if err != nil {
+		return err
+	}
+	if v, err = lookupJSON(v, bs.Path); err != nil {
+		return err
+	}
+	a, ok := v.([]interface{})
+	if !ok {
+		return fmt.Errorf("%q is not an array", bs.Path)
+	}
+
+	values := make([]interface{}, len(a))
+	for i, elem := range a {
+		if values[i], err = lookupJSON(elem, bs.Field); err != nil {
+			return fmt.Errorf("element %d: %s", i, err)
+		}
+	}
+	for i := 1; i < len(values); i++ {
+		x, y := values[i-1], values[i]
+		if desc {
+			x, y = y, x
+		}
+		less, err := lessJSON(y, x)
+		if err != nil {
+			return fmt.Errorf("elements %d and %d: %s", i-1, i, err)
+		}
+		if less {
+			return fmt.Errorf("elements %d (%v) and %d (%v) are out of order", i-1, values[i-1], i, values[i])
+		}
+	}
+	return nil
+}
+
+// checkCharset verifies that b is encoded with the charset declared by the
+// media type ct. If ct does not declare a charset, nothing is verified.
+func checkCharset(ct string, b []byte) error {
+	if ct == "" {
+		return nil
+	}
+	_, params, err := mime.ParseMediaType(ct)
+	if err != nil {
+		return err
+	}
+
+	switch cs := strings.ToLower(params["charset"]); cs {
+	case "":
+		return nil
+	case "utf-8", "utf8":
+		if !utf8.Valid(b) {
+			return fmt.Errorf("body is not valid %s", cs)
+		}
+	case "us-ascii", "ascii":
+		for i, c := range b {
+			if c >= utf8.RuneSelf {
+				return fmt.Errorf("body has non-%s byte 0x%x at offset %d", cs, c, i)
+			}
+		}
+	case "iso-8859-1", "latin1", "latin-1":
+		// Every byte sequence is valid ISO-8859-1, but multi-byte UTF-8
+		// sequences indicate the body was encoded as UTF-8 instead.
+		if utf8.Valid(b) && utf8.RuneCount(b) != len(b) {
+			return fmt.Errorf("body declared as %s appears to be utf-8", cs)
+		}
+	default:
+		return fmt.Errorf("unsupported charset %q", cs)
+	}
+	return nil
+}
+
+// diffText describes the first line in which got differs from exp.
+func diffText(got, exp string) string {
+	gotLines, expLines := strings.Split(got, "\n"), strings.Split(exp, "\n")
+	for i := 0; i < len(gotLines) || i < len(expLines); i++ {
+		var g, e string
+		if i < len(gotLines) {
+			g = gotLines[i]
+		}
+		if i < len(expLines) {
+			e = expLines[i]
+		}
+		switch {
+		case i >= len(gotLines):
+			return fmt.Sprintf("line %d: missing, expected %q", i+1, e)
+		case i >= len(expLines):
+			return fmt.Sprintf("line %d: got %q, expected no more lines", i+1, g)
+		case g != e:
+			return fmt.Sprintf("line %d: got %q, expected %q", i+1, g, e)
+		}
+	}
+	return "no difference"
+}
+
+// normalizeBodies applies the normalizations of res to the actual and the
+// expected body, body and exp.
+func (res *Response) normalizeBodies(body, exp string) (string, string) {
+	if res.NormalizeNewlines {
+		body, exp = normalizeNewlines(body), normalizeNewlines(exp)
+	}
+	if res.NormalizeWhitespace {
+		body, exp = normalizeWhitespace(body), normalizeWhitespace(exp)
+	}
+	return body, exp
+}
+
+func normalizeNewlines(s string) string {
+	return strings.Replace(s, "\r\n", "\n", -1)
+}
+
+func normalizeWhitespace(s string) string {
+	return strings.Join(strings.Fields(s), " ")
+}
+
+func isZero(i interface{}) bool {
+	return reflect.ValueOf(i).IsZero()
+}
@@ -0,0 +1,1772 @@
+package handlertest
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"gopkg.in/yaml.v2"
+)
+
+func TestAssertResponse(t *testing.T) {
+	tt := []struct {
+		name string
+
+		m     mock
+		inRec *httptest.ResponseRecorder
+		inRes *Response
+
+		expectError bool
+	}{
+		{
+			name: "OK with body",
+			inRec: &httptest.ResponseRecorder{
+				Code: http.StatusInternalServerError,
+				Body: bytes.NewBufferString("Hello world!"),
+			},
+			inRes: &Response{
+				Code: http.StatusInternalServerError,
+				Body: "Hello world!",
+			},
+		},
+		{
+			name:  "OK without body",
+			inRec: &httptest.ResponseRecorder{Code: http.StatusOK},
+			inRes: &Response{Code: http.StatusOK},
+		},
+		{
+			name: "Absent code and body",
+			inRec: &httptest.ResponseRecorder{
+				Code: http.StatusOK,
+				Body: bytes.NewBufferString("Hello world!"),
+			},
+			inRes: &Response{},
+		},
+		{
+			name: "Absent code",
+			inRec: &httptest.ResponseRecorder{
+				Code: http.StatusOK,
+				Body: bytes.NewBufferString("Hello world!"),
+			},
+			inRes: &Response{
+				Body: "Hello world!",
+			},
+		},
+		{
+			name: "Absent body",
+			inRec: &httptest.ResponseRecorder{
+				Code: http.StatusCreated,
+				Body: bytes.NewBufferString("Hello world!"),
+			},
+			inRes: &Response{
+				Code: http.StatusCreated,
+			},
+		},
+		{
+			name: "Code mismatch",
+			inRec: &httptest.ResponseRecorder{
+				Code: http.StatusInternalServerError,
+				Body: bytes.NewBufferString("Hello world!"),
+			},
+			inRes: &Response{
+				Code: http.StatusOK,
+				Body: "Hello world!",
+			},
+			expectError: true,
+		},
+		{
+			name: "Body mismatch",
+			inRec: &httptest.ResponseRecorder{
+				Code: http.StatusOK,
+				Body: bytes.NewBufferString("Hello world!"),
+			},
+			inRes: &Response{
+				Code: http.StatusOK,
+				Body: "Not hello world",
+			},
+			expectError: true,
+		},
+		{
+			name: "Normalized newlines",
+			inRec: &httptest.ResponseRecorder{
+				Code: http.StatusOK,
+				Body: bytes.NewBufferString("foo\r\nbar\r\n"),
+			},
+			inRes: &Response{
+				Body:              "foo\nbar\n",
+				NormalizeNewlines: true,
+			},
+		},
+		{
+			name: "Newlines not normalized",
+			inRec: &httptest.ResponseRecorder{
+				Code: http.StatusOK,
+				Body: bytes.NewBufferString("foo\r\nbar\r\n"),
+			},
+			inRes: &Response{
+				Body: "foo\nbar\n",
+			},
+			expectError: true,
+		},
+		{
+			name: "Normalized whitespace",
+			inRec: &httptest.ResponseRecorder{
+				Code: http.StatusOK,
+				Body: bytes.NewBufferString("<p>\n  Hello,\tworld!\n</p>\n"),
+			},
+			inRes: &Response{
+				Body:                "<p> Hello, world! </p>",
+				NormalizeWhitespace: true,
+			},
+		},
+		{
+			name: "Whitespace normalized but words differ",
+			inRec: &httptest.ResponseRecorder{
+				Code: http.StatusOK,
+				Body: bytes.NewBufferString("Hello,\nworld!\n"),
+			},
+			inRes: &Response{
+				Body:                "Hello,world!",
+				NormalizeWhitespace: true,
+			},
+			expectError: true,
+		},
+		{
+			name: "Charset matches",
+			inRec: &httptest.ResponseRecorder{
+				Code:      http.StatusOK,
+				HeaderMap: http.Header{"Content-Type": {"text/plain; charset=utf-8"}},
+				Body:      bytes.NewBufferString("Héllo wörld!"),
+			},
+			inRes: &Response{CheckCharset: true},
+		},
+		{
+			name: "Charset declares utf-8 with invalid body",
+			inRec: &httptest.ResponseRecorder{
+				Code:      http.StatusOK,
+				HeaderMap: http.Header{"Content-Type": {"text/plain; charset=utf-8"}},
+				Body:      bytes.NewBufferString("H\xe9llo"),
+			},
+			inRes:       &Response{CheckCharset: true},
+			expectError: true,
+		},
+		{
+			name: "Charset declares latin-1 with utf-8 body",
+			inRec: &httptest.ResponseRecorder{
+				Code:      http.StatusOK,
+				HeaderMap: http.Header{"Content-Type": {"text/plain; charset=ISO-8859-1"}},
+				Body:      bytes.NewBufferString("Héllo"),
+			},
+			inRes:       &Response{CheckCharset: true},
+			expectError: true,
+		},
+		{
+			name: "Last-Modified valid",
+			inRec: &httptest.ResponseRecorder{
+				Code:      http.StatusOK,
+				HeaderMap: http.Header{"Last-Modified": {"Fri, 27 Mar 2020 12:00:00 GMT"}},
+			},
+			inRes: &Response{LastModifiedNotFuture: true},
+		},
+		{
+			name:        "Last-Modified missing",
+			inRec:       &httptest.ResponseRecorder{Code: http.StatusOK},
+			inRes:       &Response{CheckLastModified: true},
+			expectError: true,
+		},
+		{
+			name: "Last-Modified invalid",
+			inRec: &httptest.ResponseRecorder{
+				Code:      http.StatusOK,
+				HeaderMap: http.Header{"Last-Modified": {"yesterday"}},
+			},
+			inRes:       &Response{CheckLastModified: true},
+			expectError: true,
+		},
+		{
+			name: "Last-Modified in the future",
+			inRec: &httptest.ResponseRecorder{
+				Code:      http.StatusOK,
+				HeaderMap: http.Header{"Last-Modified": {time.Now().Add(time.Hour).UTC().Format(http.TimeFormat)}},
+			},
+			inRes:       &Response{LastModifiedNotFuture: true},
+			expectError: true,
+		},
+		{
+			name: "Validator accepts",
+			inRec: &httptest.ResponseRecorder{
+				Code: http.StatusOK,
+				Body: bytes.NewBufferString("Hello world!"),
+			},
+			inRes: &Response{
+				Validator: []string{"sh", "-c", "grep -q Hello"},
+			},
+		},
+		{
+			name: "Validator rejects",
+			inRec: &httptest.ResponseRecorder{
+				Code: http.StatusOK,
+				Body: bytes.NewBufferString("Bye world!"),
+			},
+			inRes: &Response{
+				Validator: []string{"sh", "-c", "grep -q Hello || { echo 'missing Hello' >&2; exit 1; }"},
+			},
+			expectError: true,
+		},
+		{
+			name: "Validator not found",
+			inRec: &httptest.ResponseRecorder{
+				Code: http.StatusOK,
+				Body: bytes.NewBufferString("Hello world!"),
+			},
+			inRes: &Response{
+				Validator: []string{"clearly-non-existing-validator"},
+			},
+			expectError: true,
+		},
+		{
+			name: "Access-Control-Max-Age within bounds",
+			inRec: &httptest.ResponseRecorder{
+				Code:      http.StatusNoContent,
+				HeaderMap: http.Header{"Access-Control-Max-Age": {"600"}},
+			},
+			inRes: &Response{
+				Code:                http.StatusNoContent,
+				AccessControlMaxAge: &Bounds{Min: 600, Max: 600},
+			},
+		},
+		{
+			name: "Access-Control-Max-Age out of bounds",
+			inRec: &httptest.ResponseRecorder{
+				Code:      http.StatusNoContent,
+				HeaderMap: http.Header{"Access-Control-Max-Age": {"86400"}},
+			},
+			inRes: &Response{
+				Code:                http.StatusNoContent,
+				AccessControlMaxAge: &Bounds{Min: 60, Max: 7200},
+			},
+			expectError: true,
+		},
+		{
+			name:  "Access-Control-Max-Age missing",
+			inRec: &httptest.ResponseRecorder{Code: http.StatusNoContent},
+			inRes: &Response{
+				Code:                http.StatusNoContent,
+				AccessControlMaxAge: &Bounds{Min: 60, Max: 7200},
+			},
+			expectError: true,
+		},
+		{
+			name: "Body with terminator",
+			inRec: &httptest.ResponseRecorder{
+				Code: http.StatusOK,
+				Body: bytes.NewBufferString("data: foo\n\ndata: bar\n\n"),
+			},
+			inRes: &Response{ExpectBodySuffix: "\n\n"},
+		},
+		{
+			name: "Truncated body",
+			inRec: &httptest.ResponseRecorder{
+				Code: http.StatusOK,
+				Body: bytes.NewBufferString("data: foo\n\ndata: ba"),
+			},
+			inRes:       &Response{ExpectBodySuffix: "\n\n"},
+			expectError: true,
+		},
+		{
+			name: "Body length within tolerance",
+			inRec: &httptest.ResponseRecorder{
+				Code: http.StatusOK,
+				Body: bytes.NewBufferString("Hello world!"),
+			},
+			inRes: &Response{
+				BodyLengthBaseline:  11,
+				BodyLengthTolerance: 0.1,
+			},
+		},
+		{
+			name: "Body length outside tolerance",
+			inRec: &httptest.ResponseRecorder{
+				Code: http.StatusOK,
+				Body: bytes.NewBufferString("Hello world!"),
+			},
+			inRes: &Response{
+				BodyLengthBaseline:  20,
+				BodyLengthTolerance: 0.25,
+			},
+			expectError: true,
+		},
+		{
+			name:        "Method not allowed without Allow",
+			inRec:       &httptest.ResponseRecorder{Code: http.StatusMethodNotAllowed},
+			inRes:       &Response{Code: http.StatusMethodNotAllowed},
+			expectError: true,
+		},
+		{
+			name: "Method not allowed with Allow",
+			inRec: &httptest.ResponseRecorder{
+				Code:      http.StatusMethodNotAllowed,
+				HeaderMap: http.Header{"Allow": {"GET, HEAD"}},
+			},
+			inRes: &Response{Code: http.StatusMethodNotAllowed},
+		},
+		{
+			name: "Allowed methods in any order",
+			inRec: &httptest.ResponseRecorder{
+				Code:      http.StatusMethodNotAllowed,
+				HeaderMap: http.Header{"Allow": {"GET,head", "OPTIONS"}},
+			},
+			inRes: &Response{Code: http.StatusMethodNotAllowed, Allow: []string{"options", "HEAD", "GET"}},
+		},
+		{
+			name: "Allowed methods differ",
+			inRec: &httptest.ResponseRecorder{
+				Code:      http.StatusOK,
+				HeaderMap: http.Header{"Allow": {"GET, HEAD"}},
+			},
+			inRes:       &Response{Allow: []string{"GET", "POST"}},
+			expectError: true,
+		},
+		{
+			name: "Headers",
+			inRec: &httptest.ResponseRecorder{
+				Code:      http.StatusOK,
+				HeaderMap: http.Header{"Allow": {"GET"}, "Content-Type": {"text/plain"}},
+			},
+			inRes: &Response{
+				Headers: []string{"Allow", "Content-Type: text/plain"},
+			},
+		},
+		{
+			name: "Header missing",
+			inRec: &httptest.ResponseRecorder{
+				Code:      http.StatusOK,
+				HeaderMap: http.Header{"Content-Type": {"text/plain"}},
+			},
+			inRes: &Response{
+				Headers: []string{"Allow"},
+			},
+			expectError: true,
+		},
+		{
+			name: "Header mismatch",
+			inRec: &httptest.ResponseRecorder{
+				Code:      http.StatusOK,
+				HeaderMap: http.Header{"Content-Type": {"text/plain"}},
+			},
+			inRes: &Response{
+				Headers: []string{"Content-Type: application/json"},
+			},
+			expectError: true,
+		},
+		{
+			name: "Repeated header",
+			inRec: &httptest.ResponseRecorder{
+				Code:      http.StatusOK,
+				HeaderMap: http.Header{"Link": {"</a>; rel=prev", "</c>; rel=next"}},
+			},
+			inRes: &Response{
+				Headers: []string{"Link: </c>; rel=next"},
+			},
+		},
+		{
+			name: "Repeated header mismatch",
+			inRec: &httptest.ResponseRecorder{
+				Code:      http.StatusOK,
+				HeaderMap: http.Header{"Link": {"</a>; rel=prev", "</c>; rel=next"}},
+			},
+			inRes: &Response{
+				Headers: []string{"Link: </b>; rel=next"},
+			},
+			expectError: true,
+		},
+		{
+			name: "JSON array elements match",
+			inRec: &httptest.ResponseRecorder{
+				Code: http.StatusOK,
+				Body: bytes.NewBufferString(`[{"id": 1, "name": "foo"}, {"id": 2, "name": "bar"}]`),
+			},
+			inRes: &Response{
+				BodyJSONArray: []interface{}{
+					map[string]interface{}{"id": 1},
+					map[interface{}]interface{}{"name": "bar"},
+				},
+			},
+		},
+		{
+			name: "JSON array element mismatch",
+			inRec: &httptest.ResponseRecorder{
+				Code: http.StatusOK,
+				Body: bytes.NewBufferString(`[{"id": 1}, {"id": 2}]`),
+			},
+			inRes: &Response{
+				BodyJSONArray: []interface{}{
+					map[string]interface{}{"id": 1},
+					map[string]interface{}{"id": 3},
+				},
+			},
+			expectError: true,
+		},
+		{
+			name: "JSON array length mismatch",
+			inRec: &httptest.ResponseRecorder{
+				Code: http.StatusOK,
+				Body: bytes.NewBufferString(`[{"id": 1}]`),
+			},
+			inRes: &Response{
+				BodyJSONArray: []interface{}{
+					map[string]interface{}{"id": 1},
+					map[string]interface{}{"id": 2},
+				},
+			},
+			expectError: true,
+		},
+		{
+			name: "Sorted ascending",
+			inRec: &httptest.ResponseRecorder{
+				Code: http.StatusOK,
+				Body: bytes.NewBufferString(`{"items": [{"id": 1}, {"id": 2}, {"id": 2}]}`),
+			},
+			inRes: &Response{
+				BodySorted: &BodySorted{Path: "items", Field: "id"},
+			},
+		},
+		{
+			name: "Sorted descending",
+			inRec: &httptest.ResponseRecorder{
+				Code: http.StatusOK,
+				Body: bytes.NewBufferString(`["c", "b", "a"]`),
+			},
+			inRes: &Response{
+				BodySorted: &BodySorted{Order: "desc"},
+			},
+		},
+		{
+			name: "Not sorted",
+			inRec: &httptest.ResponseRecorder{
+				Code: http.StatusOK,
+				Body: bytes.NewBufferString(`{"items": [{"id": 2}, {"id": 1}]}`),
+			},
+			inRes: &Response{
+				BodySorted: &BodySorted{Path: "items", Field: "id"},
+			},
+			expectError: true,
+		},
+		{
+			name: "Sorted on mixed types",
+			inRec: &httptest.ResponseRecorder{
+				Code: http.StatusOK,
+				Body: bytes.NewBufferString(`[1, "2"]`),
+			},
+			inRes: &Response{
+				BodySorted: &BodySorted{},
+			},
+			expectError: true,
+		},
+	}
+	for _, tc := range tt {
+		t.Run(tc.name, func(t *testing.T) {
+			New().assertResponse(&tc.m, &exchange{rec: tc.inRec}, tc.inRes)
+			if tc.m.errored != tc.expectError {
+				t.Errorf("Got %t, expected %t", tc.m.errored, tc.expectError)
+			}
+		})
+	}
+}
+
+func TestAssertResponseFailures(t *testing.T) {
+	rec := &httptest.ResponseRecorder{
+		Code:      http.StatusInternalServerError,
+		HeaderMap: http.Header{"Content-Type": {"text/plain"}},
+		Body:      bytes.NewBufferString("Oops"),
+	}
+	res := &Response{
+		Code:    http.StatusOK,
+		Body:    "Hello world!",
+		Headers: []string{"Content-Type: application/json"},
+	}
+
+	t.Run("All failures reported by default", func(t *testing.T) {
+		var m mock
+		New().assertResponse(&m, &exchange{rec: rec}, res)
+		if len(m.errors) != 3 {
+			t.Errorf("Got %d (%q), expected 3", len(m.errors), m.errors)
+		}
+	})
+
+	t.Run("First failure reported when failing fast", func(t *testing.T) {
+		var m mock
+		New(WithFailFastWithinCase()).assertResponse(&m, &exchange{rec: rec}, res)
+		if len(m.errors) != 1 {
+			t.Errorf("Got %d (%q), expected 1", len(m.errors), m.errors)
+		}
+	})
+}
+
+func TestAssertEchoBody(t *testing.T) {
+	h := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = fmt.Fprintf(w, "%s %s\nX-Foo: %s", r.Method, r.URL.Path, r.Header.Get("X-Foo"))
+	})
+	echo := func(r *http.Request) string {
+		return r.Method + " " + r.URL.Path + "\nX-Foo: " + r.Header.Get("X-Foo")
+	}
+	tc := TestCase{
+		Request:  Request{Method: http.MethodGet, URL: "/foo", Headers: []string{"X-Foo: bar"}},
+		Response: Response{EchoBody: echo},
+	}
+
+	t.Run("Echoed", func(t *testing.T) {
+		var m mock
+		Run(&m, h, tc)
+		if m.errored {
+			t.Errorf("Got %q, expected no errors", m.errors)
+		}
+	})
+
+	t.Run("Echoed by live server", func(t *testing.T) {
+		var m mock
+		RunServer(&m, h, tc)
+		if m.errored {
+			t.Errorf("Got %q, expected no errors", m.errors)
+		}
+	})
+
+	t.Run("Not echoed", func(t *testing.T) {
+		var m mock
+		Run(&m, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			_, _ = fmt.Fprintf(w, "%s %s\nX-Foo: baz", r.Method, r.URL.Path)
+		}), tc)
+		exp := `Got response body not echoing the request: line 2: got "X-Foo: baz", expected "X-Foo: bar"`
+		if len(m.errors) != 1 || m.errors[0] != exp {
+			t.Errorf("Got %q, expected %q", m.errors, exp)
+		}
+	})
+}
+
+func TestAssertBodyRegexp(t *testing.T) {
+	body := "order 42 created at 2020-01-01T00:00:00Z by alice"
+	pattern := `^order (?P<id>\d+) created at (?P<at>\S+) by (?P<user>\w*)$`
+
+	tt := []struct {
+		name string
+
+		res *Response
+
+		expectErrors []string
+	}{
+		{
+			name: "Matching groups",
+			res: &Response{
+				BodyRegexp:         pattern,
+				BodyRegexpGroups:   map[string]string{"id": "42", "user": "alice"},
+				BodyRegexpNonEmpty: []string{"at"},
+			},
+		},
+		{
+			name:         "Not matching",
+			res:          &Response{BodyRegexp: `^order \d+$`},
+			expectErrors: []string{`Got response body "order 42 created at 2020-01-01T00:00:00Z by alice", expected it to match "^order \\d+$"`},
+		},
+		{
+			name: "Mismatched groups",
+			res: &Response{
+				BodyRegexp:         pattern,
+				BodyRegexpGroups:   map[string]string{"id": "43", "name": "alice"},
+				BodyRegexpNonEmpty: []string{"at", "user"},
+			},
+			expectErrors: []string{
+				`Got body regular expression group "id" "42", expected "43"`,
+				`Invalid body regular expression group "name": not in "^order (?P<id>\\d+) created at (?P<at>\\S+) by (?P<user>\\w*)$"`,
+			},
+		},
+		{
+			name:         "Invalid",
+			res:          &Response{BodyRegexp: "("},
+			expectErrors: []string{"Invalid body regular expression \"(\": error parsing regexp: missing closing ): `(`"},
+		},
+	}
+	for _, tc := range tt {
+		t.Run(tc.name, func(t *testing.T) {
+			var m mock
+			assertBodyRegexp(&m, &exchange{body: []byte(body)}, tc.res)
+			if !reflect.DeepEqual(m.errors, tc.expectErrors) {
+				t.Errorf("Got %q, expected %q", m.errors, tc.expectErrors)
+			}
+		})
+	}
+
+	t.Run("Empty group", func(t *testing.T) {
+		var m mock
+		assertBodyRegexp(&m, &exchange{body: []byte("order 42 created at now by ")}, &Response{
+			BodyRegexp:         pattern,
+			BodyRegexpNonEmpty: []string{"user"},
+		})
+		exp := []string{`Got empty body regular expression group "user", expected a value`}
+		if !reflect.DeepEqual(m.errors, exp) {
+			t.Errorf("Got %q, expected %q", m.errors, exp)
+		}
+	})
+
+	t.Run("From YAML", func(t *testing.T) {
+		// Single-quoted YAML strings keep backslashes, so patterns need no
+		// additional escaping.
+		y := "response:\n  bodyregexp: '^\\{\"id\": \"[0-9a-f]{8}(-[0-9a-f]{4}){3}-[0-9a-f]{12}\", \"version\": \"v\\d+\\.\\d+\"\\}$'\n"
+		var tc TestCase
+		if err := yaml.Unmarshal([]byte(y), &tc); err != nil {
+			t.Fatalf("Got %s, expected nil", err)
+		}
+		var m mock
+		assertBodyRegexp(&m, &exchange{body: []byte(`{"id": "123e4567-e89b-12d3-a456-426614174000", "version": "v1.2"}`)}, &tc.Response)
+		if m.errored {
+			t.Errorf("Got %q, expected no errors", m.errors)
+		}
+	})
+}
+
+func TestAssertKeyOrder(t *testing.T) {
+	body := []byte(`{"alg": "ES256", "payload": {"sub": "42", "iat": 1}, "sig": "abc"}`)
+
+	tt := []struct {
+		name string
+
+		exp map[string][]string
+
+		expectErrors []string
+	}{
+		{
+			name: "In order",
+			exp:  map[string][]string{"": {"alg", "payload", "sig"}, "payload": {"sub", "iat"}},
+		},
+		{
+			name: "Out of order",
+			exp:  map[string][]string{"payload": {"iat", "sub"}},
+			expectErrors: []string{
+				`Got key "sub" at position 0 of JSON object at "payload", expected "iat" (got keys ["sub" "iat"])`,
+			},
+		},
+		{
+			name: "Missing and unexpected",
+			exp:  map[string][]string{"": {"alg", "payload"}, "payload": {"sub", "iat", "exp"}},
+			expectErrors: []string{
+				`Got unexpected key "sig" at position 2 of JSON object at "", expected keys ["alg" "payload"]`,
+				`Missing key "exp" at position 2 of JSON object at "payload", got keys ["sub" "iat"]`,
+			},
+		},
+		{
+			name:         "Not an object",
+			exp:          map[string][]string{"alg": {"foo"}},
+			expectErrors: []string{`Cannot read key order of JSON object at "alg": $.alg is not an object`},
+		},
+	}
+	for _, tc := range tt {
+		t.Run(tc.name, func(t *testing.T) {
+			var m mock
+			assertKeyOrder(&m, &exchange{body: body}, &Response{ExpectKeyOrder: tc.exp})
+			if !reflect.DeepEqual(m.errors, tc.expectErrors) {
+				t.Errorf("Got %q, expected %q", m.errors, tc.expectErrors)
+			}
+		})
+	}
+}
+
+func TestAssertBodyForm(t *testing.T) {
+	exp := map[string]string{"name": "Alice Smith", "tags": "a,b", "empty": ""}
+
+	tt := []struct {
+		name string
+
+		body string
+
+		expectErrors []string
+	}{
+		{
+			name: "Equal in any order",
+			body: "tags=a%2Cb&empty=&name=Alice+Smith",
+		},
+		{
+			name: "Mismatched keys and values",
+			body: "name=Bob&tags=a%2Cb&tags=c&extra=1",
+			expectErrors: []string{
+				`Missing form key "empty" in response body, expected ""`,
+				`Got unexpected form key "extra" with ["1"] in response body`,
+				`Got form key "name" with ["Bob"] in response body, expected "Alice Smith"`,
+				`Got form key "tags" with ["a,b" "c"] in response body, expected "a,b"`,
+			},
+		},
+		{
+			name:         "Invalid encoding",
+			body:         "name=%zz",
+			expectErrors: []string{`Got response body "name=%zz", expected it to be form-encoded: invalid URL escape "%zz"`},
+		},
+	}
+	for _, tc := range tt {
+		t.Run(tc.name, func(t *testing.T) {
+			var m mock
+			assertBodyForm(&m, &exchange{body: []byte(tc.body)}, &Response{BodyForm: exp})
+			if !reflect.DeepEqual(m.errors, tc.expectErrors) {
+				t.Errorf("Got %q, expected %q", m.errors, tc.expectErrors)
+			}
+		})
+	}
+}
+
+func TestAssertForbiddenBodyPatterns(t *testing.T) {
+	patterns := []string{`goroutine \d+ \[`, `(?i)\bselect\b.+\bfrom\b`}
+
+	tt := []struct {
+		name string
+
+		code     int
+		body     string
+		patterns []string
+
+		expectErrors []string
+	}{
+		{
+			name:     "Clean error",
+			code:     http.StatusInternalServerError,
+			body:     `{"error": "internal error"}`,
+			patterns: patterns,
+		},
+		{
+			name:     "Leaking success",
+			code:     http.StatusOK,
+			body:     "goroutine 1 [running]:",
+			patterns: patterns,
+		},
+		{
+			name:     "Leaking stack trace",
+			code:     http.StatusInternalServerError,
+			body:     "panic: runtime error: index out of range\n\ngoroutine 1 [running]:\nmain.main()",
+			patterns: patterns,
+			expectErrors: []string{
+				`Got error response body matching forbidden pattern "goroutine \\d+ \\[": "...index out of range\n\ngoroutine 1 [running]:\nmain.main(..."`,
+			},
+		},
+		{
+			name:     "Leaking SQL",
+			code:     http.StatusBadRequest,
+			body:     "pq: syntax error in SELECT * FROM users",
+			patterns: patterns,
+			expectErrors: []string{
+				`Got error response body matching forbidden pattern "(?i)\\bselect\\b.+\\bfrom\\b": "pq: syntax error in SELECT * FROM users"`,
+			},
+		},
+		{
+			name:         "Invalid pattern",
+			code:         http.StatusBadRequest,
+			patterns:     []string{"("},
+			expectErrors: []string{"Invalid forbidden body pattern \"(\": error parsing regexp: missing closing ): `(`"},
+		},
+	}
+	for _, tc := range tt {
+		t.Run(tc.name, func(t *testing.T) {
+			var m mock
+			ex := &exchange{rec: &httptest.ResponseRecorder{Code: tc.code}, body: []byte(tc.body)}
+			assertForbiddenBodyPatterns(&m, ex, &Response{ForbiddenBodyPatterns: tc.patterns})
+			if !reflect.DeepEqual(m.errors, tc.expectErrors) {
+				t.Errorf("Got %q, expected %q", m.errors, tc.expectErrors)
+			}
+		})
+	}
+}
+
+func TestDiffText(t *testing.T) {
+	tt := []struct {
+		got, exp string
+
+		expect string
+	}{
+		{got: "a\nb", exp: "a\nb", expect: "no difference"},
+		{got: "a\nb", exp: "a\nc", expect: `line 2: got "b", expected "c"`},
+		{got: "a", exp: "a\nb", expect: `line 2: missing, expected "b"`},
+		{got: "a\nb", exp: "a", expect: `line 2: got "b", expected no more lines`},
+	}
+	for _, tc := range tt {
+		if got := diffText(tc.got, tc.exp); got != tc.expect {
+			t.Errorf("Got %q, expected %q", got, tc.expect)
+		}
+	}
+}
+
+func TestAcceptsEncoding(t *testing.T) {
+	tt := []struct {
+		ae, ce string
+
+		expect bool
+	}{
+		{ae: "", ce: "", expect: true},
+		{ae: "", ce: "gzip", expect: false},
+		{ae: "gzip, br", ce: "gzip", expect: true},
+		{ae: "GZIP;q=0.5", ce: "gzip", expect: true},
+		{ae: "gzip;q=0", ce: "gzip", expect: false},
+		{ae: "*", ce: "deflate", expect: true},
+		{ae: "br", ce: "identity", expect: true},
+		{ae: "*;q=0, gzip", ce: "gzip", expect: true},
+		{ae: "gzip, *;q=0", ce: "gzip", expect: true},
+		{ae: "*;q=0, gzip", ce: "deflate", expect: false},
+		{ae: "gzip;q=0, *", ce: "gzip", expect: false},
+		{ae: "gzip;q=0.0", ce: "gzip", expect: false},
+		{ae: "gzip; q=0.000", ce: "gzip", expect: false},
+		{ae: "gzip;level=1;q=0", ce: "gzip", expect: false},
+		{ae: "gzip;level=1;q=0.1", ce: "gzip", expect: true},
+		{ae: "gzip;Q=0", ce: "gzip", expect: false},
+	}
+	for _, tc := range tt {
+		if got := acceptsEncoding(tc.ae, tc.ce); got != tc.expect {
+			t.Errorf("%q, %q: got %t, expected %t", tc.ae, tc.ce, got, tc.expect)
+		}
+	}
+}
+
+func TestDecrypt(t *testing.T) {
+	xor := func(b []byte) ([]byte, error) {
+		if len(b) == 0 {
+			return nil, errors.New("empty ciphertext")
+		}
+		out := make([]byte, len(b))
+		for i := range b {
+			out[i] = b[i] ^ 42
+		}
+		return out, nil
+	}
+	ciphertext, _ := xor([]byte("Hello world!"))
+
+	tt := []struct {
+		name string
+
+		body string
+		exp  string
+
+		expectErrors int
+	}{
+		{
+			name: "Decrypted body matches",
+			body: string(ciphertext),
+			exp:  "Hello world!",
+		},
+		{
+			name:         "Decrypted body mismatch",
+			body:         string(ciphertext),
+			exp:          "Bye world!",
+			expectErrors: 1,
+		},
+		{
+			name:         "Decryption fails",
+			exp:          "Hello world!",
+			expectErrors: 2,
+		},
+	}
+	for _, tc := range tt {
+		t.Run(tc.name, func(t *testing.T) {
+			var m mock
+			rec := &httptest.ResponseRecorder{Code: http.StatusOK, Body: bytes.NewBufferString(tc.body)}
+			New().assertResponse(&m, &exchange{rec: rec}, &Response{Body: tc.exp, Decrypt: xor})
+			if len(m.errors) != tc.expectErrors {
+				t.Errorf("Got %q, expected %d errors", m.errors, tc.expectErrors)
+			}
+		})
+	}
+}
+
+func TestStripANSI(t *testing.T) {
+	tt := []struct {
+		name string
+
+		body      string
+		stripANSI bool
+		exp       string
+
+		expectErrors []string
+	}{
+		{
+			name:      "Colors stripped",
+			body:      "\x1b[1;32mok\x1b[0m: 3 passed\n",
+			stripANSI: true,
+			exp:       "ok: 3 passed\n",
+		},
+		{
+			name:      "Hyperlink and cursor escapes stripped",
+			body:      "\x1b]8;;https://example.com\x07link\x1b]8;;\x07\x1b[2K\x1bc",
+			stripANSI: true,
+			exp:       "link",
+		},
+		{
+			name:         "Stripped body mismatch",
+			body:         "\x1b[31mfail\x1b[0m",
+			stripANSI:    true,
+			exp:          "ok",
+			expectErrors: []string{`Got response body "fail", expected "ok"`},
+		},
+		{
+			name:         "Not stripped",
+			body:         "\x1b[32mok\x1b[0m",
+			exp:          "ok",
+			expectErrors: []string{`Got response body "\x1b[32mok\x1b[0m", expected "ok"`},
+		},
+	}
+	for _, tc := range tt {
+		t.Run(tc.name, func(t *testing.T) {
+			var m mock
+			rec := &httptest.ResponseRecorder{Code: http.StatusOK, Body: bytes.NewBufferString(tc.body)}
+			New().assertResponse(&m, &exchange{rec: rec}, &Response{Body: tc.exp, StripANSI: tc.stripANSI})
+			if !reflect.DeepEqual(m.errors, tc.expectErrors) {
+				t.Errorf("Got %q, expected %q", m.errors, tc.expectErrors)
+			}
+		})
+	}
+}
+
+func TestAssertBodyChecksum(t *testing.T) {
+	tt := []struct {
+		name string
+
+		checksum string
+
+		expectErrors []string
+	}{
+		{
+			name:     "Default algorithm",
+			checksum: "2cf24dba5fb0a30e26e83b2ac5b9e29e1b161e5c1fa7425e73043362938b9824",
+		},
+		{
+			name:     "Explicit algorithm",
+			checksum: "MD5:5D41402ABC4B2A76B9719D911017C592",
+		},
+		{
+			name:         "Mismatch",
+			checksum:     "md5:00000000000000000000000000000000",
+			expectErrors: []string{"Got response body with checksum md5:5d41402abc4b2a76b9719d911017c592, expected md5:00000000000000000000000000000000"},
+		},
+		{
+			name:         "Unsupported algorithm",
+			checksum:     "crc32:3610a686",
+			expectErrors: []string{`Invalid body checksum "crc32:3610a686": unsupported algorithm "crc32"`},
+		},
+	}
+	for _, tc := range tt {
+		t.Run(tc.name, func(t *testing.T) {
+			var m mock
+			assertBodyChecksum(&m, &exchange{body: []byte("hello")}, &Response{BodyChecksum: tc.checksum})
+			if !reflect.DeepEqual(m.errors, tc.expectErrors) {
+				t.Errorf("Got %q, expected %q", m.errors, tc.expectErrors)
+			}
+		})
+	}
+
+	t.Run("SHA-256", func(t *testing.T) {
+		var m mock
+		body := &exchange{body: []byte("hello")}
+		assertBodyChecksum(&m, body, &Response{BodySHA256: "2CF24DBA5FB0A30E26E83B2AC5B9E29E1B161E5C1FA7425E73043362938B9824"})
+		assertBodyChecksum(&m, body, &Response{BodySHA256: "00"})
+		exp := []string{"Got response body with checksum sha256:2cf24dba5fb0a30e26e83b2ac5b9e29e1b161e5c1fa7425e73043362938b9824, expected sha256:00"}
+		if !reflect.DeepEqual(m.errors, exp) {
+			t.Errorf("Got %q, expected %q", m.errors, exp)
+		}
+	})
+}
+
+func TestAssertBodyJSON(t *testing.T) {
+	tt := []struct {
+		name string
+
+		body   string
+		exp    string
+		subset bool
+		ignore []string
+
+		expectErrors []string
+	}{
+		{
+			name: "Key order and whitespace differ",
+			body: `{"name":"Alice","tags":["a","b"],"id":42}`,
+			exp: `{
+				"id": 42,
+				"name": "Alice",
+				"tags": ["a", "b"]
+			}`,
+		},
+		{
+			name: "Values differ",
+			body: `{"id": 42, "name": "Bob", "admin": true}`,
+			exp:  `{"id": 42, "name": "Alice", "tags": []}`,
+			expectErrors: []string{
+				`Got unexpected JSON response body: $.name: got "Bob", expected "Alice"`,
+				`Got unexpected JSON response body: $.tags: missing, expected []`,
+				`Got unexpected JSON response body: $.admin: got true, expected it to be absent`,
+			},
+		},
+		{
+			name:   "Subset",
+			body:   `{"id": "8f14e45f", "created": "2020-02-29T12:00:00Z", "user": {"id": 7, "name": "Alice"}}`,
+			exp:    `{"user": {"name": "Alice"}}`,
+			subset: true,
+		},
+		{
+			name:         "Subset with differing value",
+			body:         `{"id": "8f14e45f", "user": {"id": 7, "name": "Bob"}}`,
+			exp:          `{"user": {"name": "Alice"}}`,
+			subset:       true,
+			expectErrors: []string{`Got unexpected JSON response body: $.user.name: got "Bob", expected "Alice"`},
+		},
+		{
+			name:   "Ignored fields",
+			body:   `{"id": 42, "created_at": "2020-02-29T12:00:00Z", "meta": {"request_id": "abc"}}`,
+			exp:    `{"id": 42, "created_at": "2000-01-01T00:00:00Z", "meta": {}}`,
+			ignore: []string{"created_at", "meta.request_id"},
+		},
+		{
+			name:         "Invalid actual body",
+			body:         `{"id": 42`,
+			exp:          `{"id": 42}`,
+			expectErrors: []string{"Got invalid JSON response body: unexpected end of JSON input"},
+		},
+		{
+			name:         "Invalid expected body",
+			body:         `{"id": 42}`,
+			exp:          `{id: 42}`,
+			expectErrors: []string{"Invalid expected JSON body: invalid character 'i' looking for beginning of object key string"},
+		},
+	}
+	for _, tc := range tt {
+		t.Run(tc.name, func(t *testing.T) {
+			var m mock
+			assertBodyJSON(&m, &exchange{body: []byte(tc.body)}, &Response{BodyJSON: tc.exp, JSONSubset: tc.subset, JSONIgnoreFields: tc.ignore})
+			if !reflect.DeepEqual(m.errors, tc.expectErrors) {
+				t.Errorf("Got %q, expected %q", m.errors, tc.expectErrors)
+			}
+		})
+	}
+}
+
+func TestAssertJSONPaths(t *testing.T) {
+	body := `{"items": [{"id": 42, "tags": ["a"]}, {"id": 43}], "error": null, "total": 2}`
+
+	tt := []struct {
+		name string
+
+		paths map[string]interface{}
+		exist []string
+
+		expectErrors []string
+	}{
+		{
+			name: "Matching",
+			paths: map[string]interface{}{
+				"$.items[0].id":   42,
+				"items[0].tags":   []interface{}{"a"},
+				"$.total":         2,
+				"$.items[1]":      map[interface{}]interface{}{"id": 43},
+				"$.error":         nil,
+				"$.items[0].tags": []string{"a"},
+			},
+			exist: []string{"$.error", "$.items[1].id"},
+		},
+		{
+			name:         "Differing value",
+			paths:        map[string]interface{}{"$.items[1].id": 42},
+			expectErrors: []string{"Got unexpected JSON response body: $.items[1].id: got 43, expected 42"},
+		},
+		{
+			name:  "Missing",
+			paths: map[string]interface{}{"$.items[2].id": 44},
+			exist: []string{"$.data"},
+			expectErrors: []string{
+				"Got JSON response body without $.items[2].id: $.items[2] does not exist",
+				"Got JSON response body without $.data: $.data does not exist",
+			},
+		},
+		{
+			name:         "Invalid path",
+			exist:        []string{"$.items[x]"},
+			expectErrors: []string{`Invalid JSON path "$.items[x]": path has invalid index: "x"`},
+		},
+	}
+	for _, tc := range tt {
+		t.Run(tc.name, func(t *testing.T) {
+			var m mock
+			assertJSONPaths(&m, &exchange{body: []byte(body)}, &Response{JSONPaths: tc.paths, JSONPathsExist: tc.exist})
+			if !reflect.DeepEqual(m.errors, tc.expectErrors) {
+				t.Errorf("Got %q, expected %q", m.errors, tc.expectErrors)
+			}
+		})
+	}
+
+	t.Run("From YAML", func(t *testing.T) {
+		var tc TestCase
+		y := "response:\n  jsonpaths:\n    $.items[0].id: 42\n  jsonpathsexist: [$.error]\n"
+		if err := yaml.Unmarshal([]byte(y), &tc); err != nil {
+			t.Fatalf("Got %s, expected nil", err)
+		}
+		var m mock
+		assertJSONPaths(&m, &exchange{body: []byte(body)}, &tc.Response)
+		if len(tc.Response.JSONPaths) != 1 || len(tc.Response.JSONPathsExist) != 1 || m.errored {
+			t.Errorf("Got %v and %q, expected one path of each and no errors", tc.Response, m.errors)
+		}
+	})
+}
+
+func TestAssertBodyContains(t *testing.T) {
+	body := `{"id": 42, "status": "shipped", "updated": "2020-02-29T12:00:00Z"}`
+
+	tt := []struct {
+		name string
+
+		yaml string
+
+		expectErrors []string
+	}{
+		{
+			name: "Single fragment",
+			yaml: `bodycontains: '"status": "shipped"'`,
+		},
+		{
+			name: "List of fragments",
+			yaml: "bodycontains:\n  - '\"id\": 42'\n  - shipped",
+		},
+		{
+			name: "Missing fragment",
+			yaml: "bodycontains: [shipped, delivered]",
+			expectErrors: []string{
+				`Got response body "{\"id\": 42, \"status\": \"shipped\", \"updated\": \"2020-02-29T12:00:00Z\"}", expected it to contain "delivered"`,
+			},
+		},
+	}
+	for _, tc := range tt {
+		t.Run(tc.name, func(t *testing.T) {
+			var res Response
+			if err := yaml.Unmarshal([]byte(tc.yaml), &res); err != nil {
+				t.Fatalf("Got %s, expected nil", err)
+			}
+
+			var m mock
+			assertBodyContains(&m, &exchange{body: []byte(body)}, &res)
+			if !reflect.DeepEqual(m.errors, tc.expectErrors) {
+				t.Errorf("Got %q, expected %q", m.errors, tc.expectErrors)
+			}
+		})
+	}
+
+	t.Run("Invalid YAML", func(t *testing.T) {
+		var res Response
+		if err := yaml.Unmarshal([]byte("bodycontains: {a: b}"), &res); err == nil {
+			t.Errorf("Got nil, expected error")
+		}
+	})
+}
+
+func TestNegatedAssertions(t *testing.T) {
+	body := "panic: runtime error: invalid memory address\ngoroutine 1 [running]:\nmain.handler()"
+
+	tt := []struct {
+		name string
+
+		code int
+		res  Response
+
+		expectErrors []string
+	}{
+		{
+			name: "Any other code",
+			code: http.StatusNotFound,
+			res:  Response{NotCode: http.StatusInternalServerError},
+		},
+		{
+			name:         "Forbidden code",
+			code:         http.StatusInternalServerError,
+			res:          Response{NotCode: http.StatusInternalServerError},
+			expectErrors: []string{"Got response code 500, expected any other"},
+		},
+		{
+			name:         "Code and NotCode",
+			code:         http.StatusNotFound,
+			res:          Response{Code: http.StatusOK, NotCode: http.StatusInternalServerError},
+			expectErrors: []string{"Got response code 404, expected 200"},
+		},
+		{
+			name: "Any other body",
+			code: http.StatusOK,
+			res:  Response{NotBody: "ok"},
+		},
+		{
+			name:         "Forbidden body",
+			code:         http.StatusOK,
+			res:          Response{NotBody: body},
+			expectErrors: []string{fmt.Sprintf("Got response body %q, expected any other", body)},
+		},
+		{
+			name: "Forbidden fragments",
+			code: http.StatusOK,
+			res:  Response{NotBodyContains: StringList{"secret", "goroutine"}},
+			expectErrors: []string{
+				`Got response body containing "goroutine", expected it not to: "...alid memory address\ngoroutine 1 [running]:\nmain.h..."`,
+			},
+		},
+	}
+	for _, tc := range tt {
+		t.Run(tc.name, func(t *testing.T) {
+			var m mock
+			rec := &httptest.ResponseRecorder{Code: tc.code, Body: bytes.NewBufferString(body)}
+			New().assertResponse(&m, &exchange{rec: rec}, &tc.res)
+			if !reflect.DeepEqual(m.errors, tc.expectErrors) {
+				t.Errorf("Got %q, expected %q", m.errors, tc.expectErrors)
+			}
+		})
+	}
+}
+
+func TestNormalizedSubstringAssertions(t *testing.T) {
+	body := "line one\r\nline   two\r\n"
+
+	tt := []struct {
+		name string
+
+		res Response
+
+		expectErrors []string
+	}{
+		{
+			name: "Contains with newlines normalized",
+			res:  Response{BodyContains: StringList{"one\nline"}, NormalizeNewlines: true},
+		},
+		{
+			name: "Contains with whitespace normalized",
+			res:  Response{BodyContains: StringList{"one line two"}, NormalizeWhitespace: true},
+		},
+		{
+			name: "Suffix with newlines normalized",
+			res:  Response{ExpectBodySuffix: "two\n", NormalizeNewlines: true},
+		},
+		{
+			name:         "Not body with newlines normalized",
+			res:          Response{NotBody: "line one\nline   two\n", NormalizeNewlines: true},
+			expectErrors: []string{`Got response body "line one\nline   two\n", expected any other`},
+		},
+		{
+			name:         "Not contains with whitespace normalized",
+			res:          Response{NotBodyContains: StringList{"one line"}, NormalizeWhitespace: true},
+			expectErrors: []string{`Got response body containing "one line", expected it not to: "line one line two"`},
+		},
+	}
+	for _, tc := range tt {
+		t.Run(tc.name, func(t *testing.T) {
+			var m mock
+			ex := &exchange{body: []byte(body)}
+			for _, assert := range []assertion{assertBodyContains, assertBodySuffix, assertNotBody, assertNotBodyContains} {
+				assert(&m, ex, &tc.res)
+			}
+			if !reflect.DeepEqual(m.errors, tc.expectErrors) {
+				t.Errorf("Got %q, expected %q", m.errors, tc.expectErrors)
+			}
+		})
+	}
+}
+
+func TestAssertCodeClass(t *testing.T) {
+	tt := []struct {
+		name string
+
+		code int
+		res  Response
+
+		expectErrors []string
+	}{
+		{
+			name: "In class",
+			code: http.StatusAccepted,
+			res:  Response{CodeClass: "2xx"},
+		},
+		{
+			name: "Uppercase class",
+			code: http.StatusConflict,
+			res:  Response{CodeClass: "4XX"},
+		},
+		{
+			name:         "Outside class",
+			code:         http.StatusInternalServerError,
+			res:          Response{CodeClass: "4xx"},
+			expectErrors: []string{"Got response code 500, expected 4xx"},
+		},
+		{
+			name:         "In class with differing code",
+			code:         http.StatusNotFound,
+			res:          Response{Code: http.StatusGone, CodeClass: "4xx"},
+			expectErrors: []string{"Got response code 404, expected 410"},
+		},
+		{
+			name:         "Invalid class",
+			code:         http.StatusOK,
+			res:          Response{CodeClass: "2x"},
+			expectErrors: []string{`Invalid code class "2x", expected one of 1xx through 5xx`},
+		},
+	}
+	for _, tc := range tt {
+		t.Run(tc.name, func(t *testing.T) {
+			var m mock
+			assertCode(&m, &exchange{rec: &httptest.ResponseRecorder{Code: tc.code}}, &tc.res)
+			if !reflect.DeepEqual(m.errors, tc.expectErrors) {
+				t.Errorf("Got %q, expected %q", m.errors, tc.expectErrors)
+			}
+		})
+	}
+}
+
+func TestAssertCodes(t *testing.T) {
+	tt := []struct {
+		name string
+
+		code int
+		res  Response
+
+		expectErrors []string
+	}{
+		{
+			name: "One of codes",
+			code: http.StatusNoContent,
+			res:  Response{Codes: []int{http.StatusOK, http.StatusNoContent}},
+		},
+		{
+			name:         "None of codes",
+			code:         http.StatusNotFound,
+			res:          Response{Codes: []int{http.StatusOK, http.StatusNoContent}},
+			expectErrors: []string{"Got response code 404, expected one of [200 204]"},
+		},
+		{
+			name: "Any code",
+			code: http.StatusTeapot,
+			res:  Response{CodeAny: true},
+		},
+		{
+			name:         "Implicit 200",
+			code:         http.StatusTeapot,
+			expectErrors: []string{"Got response code 418, expected 200"},
+		},
+		{
+			name: "Codes within class",
+			code: http.StatusAccepted,
+			res:  Response{Codes: []int{http.StatusAccepted}, CodeClass: "2xx"},
+		},
+	}
+	for _, tc := range tt {
+		t.Run(tc.name, func(t *testing.T) {
+			var m mock
+			assertCode(&m, &exchange{rec: &httptest.ResponseRecorder{Code: tc.code}}, &tc.res)
+			if !reflect.DeepEqual(m.errors, tc.expectErrors) {
+				t.Errorf("Got %q, expected %q", m.errors, tc.expectErrors)
+			}
+		})
+	}
+
+	t.Run("From YAML", func(t *testing.T) {
+		var res Response
+		if err := yaml.Unmarshal([]byte("codes: [200, 204]"), &res); err != nil {
+			t.Fatalf("Got %s, expected nil", err)
+		}
+		if exp := []int{200, 204}; !reflect.DeepEqual(res.Codes, exp) {
+			t.Errorf("Got %v, expected %v", res.Codes, exp)
+		}
+	})
+}
+
+func TestAssertEmptyBody(t *testing.T) {
+	tt := []struct {
+		name string
+
+		body string
+		res  Response
+
+		expectErrors []string
+	}{
+		{
+			name: "Empty",
+			res:  Response{EmptyBody: true},
+		},
+		{
+			name:         "Not empty",
+			body:         "\n",
+			res:          Response{EmptyBody: true},
+			expectErrors: []string{`Got response body "\n", expected it to be empty`},
+		},
+		{
+			name: "Not asserted",
+			body: "ok",
+		},
+	}
+	for _, tc := range tt {
+		t.Run(tc.name, func(t *testing.T) {
+			var m mock
+			assertBody(&m, &exchange{body: []byte(tc.body)}, &tc.res)
+			if !reflect.DeepEqual(m.errors, tc.expectErrors) {
+				t.Errorf("Got %q, expected %q", m.errors, tc.expectErrors)
+			}
+		})
+	}
+}
+
+func TestAssertTrailers(t *testing.T) {
+	h := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Trailer", "X-Checksum")
+		_, _ = w.Write([]byte("chunk"))
+		w.Header().Set("X-Checksum", "sha256:abc")
+		w.Header().Set(http.TrailerPrefix+"X-Rows", "1")
+	})
+
+	tt := []struct {
+		name string
+
+		trailers []string
+
+		expectErrors []string
+	}{
+		{
+			name:     "Matching",
+			trailers: []string{"X-Checksum: sha256:abc", "X-Rows"},
+		},
+		{
+			name:     "Differing and missing",
+			trailers: []string{"X-Checksum: sha256:def", "X-Status"},
+			expectErrors: []string{
+				`Got response trailer X-Checksum "sha256:abc", expected "sha256:def"`,
+				`Missing response trailer "X-Status"`,
+			},
+		},
+	}
+	for _, r := range []*Runner{New(), New(WithServer())} {
+		for _, tc := range tt {
+			t.Run(tc.name, func(t *testing.T) {
+				var m mock
+				r.Run(&m, h, TestCase{
+					Request:  Request{Method: http.MethodGet, URL: "/export"},
+					Response: Response{Trailers: tc.trailers},
+				})
+				if !reflect.DeepEqual(m.errors, tc.expectErrors) {
+					t.Errorf("Got %q, expected %q", m.errors, tc.expectErrors)
+				}
+			})
+		}
+	}
+}
+
+func TestAssertBodyLength(t *testing.T) {
+	tt := []struct {
+		name string
+
+		res Response
+
+		expectErrors []string
+	}{
+		{
+			name: "Exact length and within limit",
+			res:  Response{BodyLength: 5, MaxBodyBytes: 5},
+		},
+		{
+			name:         "Differing length",
+			res:          Response{BodyLength: 4},
+			expectErrors: []string{"Got response body of 5 bytes, expected 4"},
+		},
+		{
+			name:         "Exceeding limit",
+			res:          Response{MaxBodyBytes: 3},
+			expectErrors: []string{"Got response body of 5 bytes, expected at most 3"},
+		},
+	}
+	for _, tc := range tt {
+		t.Run(tc.name, func(t *testing.T) {
+			var m mock
+			assertBodyLength(&m, &exchange{body: []byte("hello")}, &tc.res)
+			if !reflect.DeepEqual(m.errors, tc.expectErrors) {
+				t.Errorf("Got %q, expected %q", m.errors, tc.expectErrors)
+			}
+		})
+	}
+}
+
+func TestAssertNotHeaders(t *testing.T) {
+	rec := &httptest.ResponseRecorder{
+		Code: http.StatusOK,
+		HeaderMap: http.Header{
+			"Content-Type": {"text/plain"},
+			"X-Powered-By": {"PHP/7.4", "Express"},
+		},
+	}
+
+	tt := []struct {
+		name string
+
+		notHeaders []string
+
+		expectErrors []string
+	}{
+		{
+			name:       "Absent",
+			notHeaders: []string{"Server", "X-AspNet-Version"},
+		},
+		{
+			name:         "Present",
+			notHeaders:   []string{"Server", "x-powered-by"},
+			expectErrors: []string{`Got response header x-powered-by "PHP/7.4, Express", expected it to be absent`},
+		},
+	}
+	for _, tc := range tt {
+		t.Run(tc.name, func(t *testing.T) {
+			var m mock
+			assertNotHeaders(&m, &exchange{rec: rec}, &Response{NotHeaders: tc.notHeaders})
+			if !reflect.DeepEqual(m.errors, tc.expectErrors) {
+				t.Errorf("Got %q, expected %q", m.errors, tc.expectErrors)
+			}
+		})
+	}
+}
+
+func TestAssertRedirectTo(t *testing.T) {
+	h := func(code int, location string) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if location != "" {
+				w.Header().Set("Location", location)
+			}
+			w.WriteHeader(code)
+		})
+	}
+
+	tt := []struct {
+		name string
+
+		h          http.Handler
+		redirectTo string
+		code       int
+
+		expectErrors []string
+	}{
+		{
+			name:       "Relative location",
+			h:          h(http.StatusFound, "/login?next=%2Faccount"),
+			redirectTo: "/login?next=%2Faccount",
+		},
+		{
+			name:       "Absolute location for relative target",
+			h:          h(http.StatusSeeOther, "http://example.com/login"),
+			redirectTo: "/login",
+		},
+		{
+			name:       "Location relative to the request path",
+			h:          h(http.StatusMovedPermanently, "settings"),
+			redirectTo: "/account/settings",
+		},
+		{
+			name:         "Differing location",
+			h:            h(http.StatusFound, "/signup"),
+			redirectTo:   "/login",
+			expectErrors: []string{`Got Location "/signup", expected a redirect to /login`},
+		},
+		{
+			name:         "Other host",
+			h:            h(http.StatusFound, "https://evil.example/login"),
+			redirectTo:   "/login",
+			expectErrors: []string{`Got Location "https://evil.example/login", expected a redirect to /login`},
+		},
+		{
+			name:         "Not a redirect",
+			h:            h(http.StatusOK, ""),
+			redirectTo:   "/login",
+			expectErrors: []string{"Got response code 200, expected a redirect to /login"},
+		},
+		{
+			name:         "Missing location",
+			h:            h(http.StatusFound, ""),
+			redirectTo:   "/login",
+			expectErrors: []string{"Missing Location header, expected /login"},
+		},
+		{
+			name:         "Differing code",
+			h:            h(http.StatusFound, "/login"),
+			redirectTo:   "/login",
+			code:         http.StatusMovedPermanently,
+			expectErrors: []string{"Got response code 302, expected 301"},
+		},
+	}
+	for _, tc := range tt {
+		t.Run(tc.name, func(t *testing.T) {
+			var m mock
+			Run(&m, tc.h, TestCase{
+				Request:  Request{Method: http.MethodGet, URL: "/account/"},
+				Response: Response{Code: tc.code, RedirectTo: tc.redirectTo},
+			})
+			if !reflect.DeepEqual(m.errors, tc.expectErrors) {
+				t.Errorf("Got %q, expected %q", m.errors, tc.expectErrors)
+			}
+		})
+	}
+}
+
+func TestAssertContentType(t *testing.T) {
+	tt := []struct {
+		name string
+
+		contentType string
+		exp         string
+
+		expectErrors []string
+	}{
+		{
+			name:        "Charset ignored",
+			contentType: "application/json; charset=utf-8",
+			exp:         "application/json",
+		},
+		{
+			name:        "Case insensitive",
+			contentType: "Text/HTML",
+			exp:         "text/html",
+		},
+		{
+			name:         "Differing media type",
+			contentType:  "text/plain; charset=utf-8",
+			exp:          "application/json",
+			expectErrors: []string{`Got Content-Type "text/plain; charset=utf-8", expected media type application/json`},
+		},
+		{
+			name:         "Missing",
+			exp:          "application/json",
+			expectErrors: []string{`Got invalid Content-Type "", expected media type application/json: mime: no media type`},
+		},
+	}
+	for _, tc := range tt {
+		t.Run(tc.name, func(t *testing.T) {
+			rec := httptest.NewRecorder()
+			if tc.contentType != "" {
+				rec.Header().Set("Content-Type", tc.contentType)
+			}
+
+			var m mock
+			assertContentType(&m, &exchange{rec: rec}, &Response{ContentType: tc.exp})
+			if !reflect.DeepEqual(m.errors, tc.expectErrors) {
+				t.Errorf("Got %q, expected %q", m.errors, tc.expectErrors)
+			}
+		})
+	}
+}
+
+func TestAssertBodyBytes(t *testing.T) {
+	body := []byte{0x89, 'P', 'N', 'G', 0x0d, 0x0a}
+
+	tt := []struct {
+		name string
+
+		yaml string
+
+		expectErrors []string
+	}{
+		{
+			name: "Base64",
+			yaml: "bodybase64: iVBORw0K",
+		},
+		{
+			name: "Wrapped hex",
+			yaml: "bodyhex: |\n  89504e47\n  0d0a\n",
+		},
+		{
+			name:         "Differing byte",
+			yaml:         "bodyhex: 89504e470a0a",
+			expectErrors: []string{"Got response body of 6 bytes differing from BodyHex at byte 4, expected 6 bytes"},
+		},
+		{
+			name:         "Truncated",
+			yaml:         "bodybase64: iVBORw0KGgo=",
+			expectErrors: []string{"Got response body of 6 bytes differing from BodyBase64 at byte 6, expected 8 bytes"},
+		},
+		{
+			name:         "Invalid encoding",
+			yaml:         "bodyhex: 8950zz",
+			expectErrors: []string{"Invalid BodyHex: encoding/hex: invalid byte: U+007A 'z'"},
+		},
+	}
+	for _, tc := range tt {
+		t.Run(tc.name, func(t *testing.T) {
+			var res Response
+			if err := yaml.Unmarshal([]byte(tc.yaml), &res); err != nil {
+				t.Fatalf("Got %s, expected nil", err)
+			}
+
+			var m mock
+			assertBodyBytes(&m, &exchange{body: body}, &res)
+			if !reflect.DeepEqual(m.errors, tc.expectErrors) {
+				t.Errorf("Got %q, expected %q", m.errors, tc.expectErrors)
+			}
+		})
+	}
+}
+
+func TestAssertFunc(t *testing.T) {
+	h := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/csv")
+		_, _ = w.Write([]byte("id,name\n1,Alice\n2,Bob\n"))
+	})
+	var failures []string
+	rows := func(n int) func(t *testing.T, res *http.Response) {
+		return func(t *testing.T, res *http.Response) {
+			b, err := ioutil.ReadAll(res.Body)
+			if err != nil {
+				t.Fatalf("Got %s, expected nil", err)
+			}
+			if got := strings.Count(string(b), "\n") - 1; got != n {
+				failures = append(failures, "rows")
+				t.Errorf("Got %d rows for %s, expected %d", got, res.Request.URL.Path, n)
+			}
+			if ct := res.Header.Get("Content-Type"); ct != "text/csv" {
+				failures = append(failures, "Content-Type")
+				t.Errorf("Got %q, expected text/csv", ct)
+			}
+		}
+	}
+
+	t.Run("Passing", func(t *testing.T) {
+		var m mock
+		m.runFunc = t.Run
+		Run(&m, h, TestCase{
+			Request:  Request{Method: http.MethodGet, URL: "/export.csv"},
+			Response: Response{Assert: rows(2)},
+		})
+		if m.errored {
+			t.Errorf("Got %q, expected no errors", m.errors)
+		}
+	})
+
+	t.Run("Failing", func(t *testing.T) {
+		var m mock
+		var name string
+		var failed bool
+		m.runFunc = func(n string, f func(t *testing.T)) bool {
+			// Run f on a T of its own, so its failures do not fail this test.
+			name = n
+			ft := &testing.T{}
+			done := make(chan struct{})
+			go func() {
+				defer close(done)
+				f(ft)
+			}()
+			<-done
+			failed = ft.Failed()
+			return !failed
+		}
+		failures = nil
+		New(WithFailFastWithinCase()).Run(&m, h, TestCase{
+			Request:  Request{Method: http.MethodGet, URL: "/export.csv"},
+			Response: Response{Assert: rows(3)},
+		})
+		if name != "Assert" {
+			t.Errorf("Got %q, expected Assert", name)
+		}
+		if !failed {
+			t.Errorf("Got false, expected true")
+		}
+		if len(failures) != 1 || failures[0] != "rows" {
+			t.Errorf("Got %q, expected [\"rows\"]", failures)
+		}
+	})
+}
@@ -0,0 +1,78 @@
+package handlertest
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+)
+
+// captureRef matches references to captured values, e.g. {{.captured.token}}.
+var captureRef = regexp.MustCompile(`\{\{\s*\.captured\.([\w-]+)\s*\}\}`)
+
+// resolveCaptured replaces references to captured values in the request and
+// the expected response of tc. It returns an error listing the references
+// that were not captured by an earlier test case.
+func resolveCaptured(tc *TestCase, captured map[string]string) error {
+	missing := make(map[string]bool)
+	resolve := func(s string) string {
+		return captureRef.ReplaceAllStringFunc(s, func(ref string) string {
+			name := captureRef.FindStringSubmatch(ref)[1]
+			v, ok := captured[name]
+			if !ok {
+				missing[name] = true
+				return ref
+			}
+			return v
+		})
+	}
+	resolveAll := func(ss []string) []string {
+		if ss == nil {
+			return nil
+		}
+		resolved := make([]string, len(ss))
+		for i, s := range ss {
+			resolved[i] = resolve(s)
+		}
+		return resolved
+	}
+
+	tc.Request.URL = resolve(tc.Request.URL)
+	tc.Request.Body = resolve(tc.Request.Body)
+	tc.Request.Headers = resolveAll(tc.Request.Headers)
+	tc.Response.Body = resolve(tc.Response.Body)
+	tc.Response.Headers = resolveAll(tc.Response.Headers)
+
+	if len(missing) > 0 {
+		names := make([]string, 0, len(missing))
+		for name := range missing {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		return fmt.Errorf("unresolved references to captured values %q", names)
+	}
+	return nil
+}
+
+// capture stores the values res.Capture refers to in captured.
+func capture(t tt, ex *exchange, res *Response, captured map[string]string) {
+	if len(res.Capture) == 0 {
+		return
+	}
+	v, err := decodeJSON(ex.body)
+	if err != nil {
+		t.Errorf("Cannot capture from invalid JSON response body: %s", err)
+		return
+	}
+	for name, path := range res.Capture {
+		cv, err := lookupJSON(v, path)
+		if err != nil {
+			t.Errorf("Cannot capture %q: %s", name, err)
+			continue
+		}
+		if s, ok := cv.(string); ok {
+			captured[name] = s
+		} else {
+			captured[name] = formatJSON(cv)
+		}
+	}
+}
@@ -0,0 +1,69 @@
+package handlertest
+
+import (
+	"io/ioutil"
+	"net/http"
+	"testing"
+)
+
+func TestCapture(t *testing.T) {
+	var stored string
+	h := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case http.MethodPost:
+			b, _ := ioutil.ReadAll(r.Body)
+			stored = string(b)
+			_, _ = w.Write([]byte(`{"data": {"id": "abc", "num": 42}}`))
+		case http.MethodGet:
+			if r.URL.Path != "/items/abc" || r.Header.Get("X-Num") != "42" {
+				http.NotFound(w, r)
+				return
+			}
+			_, _ = w.Write([]byte(stored))
+		}
+	})
+
+	t.Run("Round trip", func(t *testing.T) {
+		var m mock
+		Run(&m, h, TestCase{
+			Request: Request{Method: http.MethodPost, URL: "/items", Body: "Hello world!"},
+			Response: Response{
+				Capture: map[string]string{"id": "data.id", "num": "$.data.num"},
+			},
+		}, TestCase{
+			Request: Request{
+				Method:  http.MethodGet,
+				URL:     "/items/{{.captured.id}}",
+				Headers: []string{"X-Num: {{ .captured.num }}"},
+			},
+			Response: Response{Body: "Hello world!"},
+		})
+
+		if m.errored {
+			t.Errorf("Got %q, expected no errors", m.errors)
+		}
+	})
+
+	t.Run("Unresolved reference", func(t *testing.T) {
+		var m mock
+		Run(&m, h, TestCase{
+			Request: Request{Method: http.MethodGet, URL: "/items/{{.captured.id}}"},
+		})
+
+		if len(m.errors) != 1 {
+			t.Errorf("Got %q, expected 1 error", m.errors)
+		}
+	})
+
+	t.Run("Path not in body", func(t *testing.T) {
+		var m mock
+		Run(&m, h, TestCase{
+			Request:  Request{Method: http.MethodPost, URL: "/items"},
+			Response: Response{Capture: map[string]string{"id": "data.foo"}},
+		})
+
+		if !m.errored {
+			t.Errorf("Got false, expected true")
+		}
+	})
+}
@@ -0,0 +1,219 @@
+package handlertest
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"sort"
+)
+
+// RunCompareHeaders fires the request of every test case at both bare and
+// wrapped, which is bare wrapped in middleware, and asserts the response
+// headers named in headers are identical for both. Any header the middleware
+// altered, added or dropped is reported. The expected responses of the test
+// cases are not asserted.
+func RunCompareHeaders(t tt, bare, wrapped http.Handler, headers []string, tcs ...TestCase) {
+	for _, tc := range tcs {
+		runNamed(t, tc.Name, func(t tt) {
+			exp, act := httptest.NewRecorder(), httptest.NewRecorder()
+			if p := serve(bare, exp, httpRequest(&tc.Request)); p != nil {
+				t.Errorf("Bare handler panicked: %v", p)
+				return
+			}
+			if p := serve(wrapped, act, httpRequest(&tc.Request)); p != nil {
+				t.Errorf("Wrapped handler panicked: %v", p)
+				return
+			}
+
+			expHdr, actHdr := exp.Result().Header, act.Result().Header
+			for _, k := range headers {
+				k = http.CanonicalHeaderKey(k)
+				ev, eok := expHdr[k]
+				av, aok := actHdr[k]
+				switch {
+				case eok && !aok:
+					t.Errorf("Middleware dropped header %s %q", k, ev)
+				case !eok && aok:
+					t.Errorf("Middleware added header %s %q", k, av)
+				case !reflect.DeepEqual(ev, av):
+					t.Errorf("Middleware altered header %s from %q to %q", k, ev, av)
+				}
+			}
+		})
+	}
+}
+
+// RunReference fires the request of every test case at both reference, a
+// reference implementation, and h, and asserts the response of h matches the
+// one of reference: the same status code, the same headers, except those in
+// ignoreHeaders, and the same body. If both bodies are JSON, these are
+// compared structurally, so formatting and key order do not matter. The
+// expected responses of the test cases are not asserted.
+func RunReference(t tt, reference, h http.Handler, ignoreHeaders []string, tcs ...TestCase) {
+	ignore := make(map[string]bool, len(ignoreHeaders))
+	for _, k := range ignoreHeaders {
+		ignore[http.CanonicalHeaderKey(k)] = true
+	}
+	for _, tc := range tcs {
+		runNamed(t, tc.Name, func(t tt) {
+			exp, act := httptest.NewRecorder(), httptest.NewRecorder()
+			if p := serve(reference, exp, httpRequest(&tc.Request)); p != nil {
+				t.Errorf("Reference handler panicked: %v", p)
+				return
+			}
+			if p := serve(h, act, httpRequest(&tc.Request)); p != nil {
+				t.Errorf("Handler panicked: %v", p)
+				return
+			}
+
+			if act.Code != exp.Code {
+				t.Errorf("Got response code %d, expected %d as the reference", act.Code, exp.Code)
+			}
+			expHdr, actHdr := exp.Result().Header, act.Result().Header
+			for _, k := range sortedHeaderKeys(expHdr, actHdr) {
+				if ignore[k] {
+					continue
+				}
+				ev, eok := expHdr[k]
+				av, aok := actHdr[k]
+				switch {
+				case !aok:
+					t.Errorf("Missing header %s, expected %q as the reference", k, ev)
+				case !eok:
+					t.Errorf("Got header %s %q, expected it to be absent as in the reference", k, av)
+				case !reflect.DeepEqual(ev, av):
+					t.Errorf("Got header %s %q, expected %q as the reference", k, av, ev)
+				}
+			}
+
+			expBody, actBody := exp.Body.String(), act.Body.String()
+			ev, eerr := decodeJSON(exp.Body.Bytes())
+			av, aerr := decodeJSON(act.Body.Bytes())
+			if eerr == nil && aerr == nil {
+				var d jsonDiffer
+				for _, diff := range d.diff(ev, av, nil) {
+					t.Errorf("Got response body diverging from the reference: %s", diff)
+				}
+			} else if actBody != expBody {
+				t.Errorf("Got response body diverging from the reference: %s", diffText(actBody, expBody))
+			}
+		})
+	}
+}
+
+// sortedHeaderKeys returns the keys of both headers, sorted.
+func sortedHeaderKeys(a, b http.Header) []string {
+	keys := make([]string, 0, len(a)+len(b))
+	for k := range a {
+		keys = append(keys, k)
+	}
+	for k := range b {
+		if _, ok := a[k]; !ok {
+			keys = append(keys, k)
+		}
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// RunErrorFormatCheck fires every trigger, a test case of which the request
+// makes a handler respond with an error, at each of handlers, and asserts all
+// error responses share a format: a JSON object with the same keys, of which
+// the values have the same JSON types. The first error response, that of the
+// first handler by name, is the reference the others are compared to. The
+// expected responses of the triggers are asserted as well. Failures are
+// prefixed with the key of the handler in handlers.
+func RunErrorFormatCheck(t tt, handlers map[string]http.Handler, triggers []TestCase) {
+	names := make([]string, 0, len(handlers))
+	for name := range handlers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var ref map[string]string
+	var refName string
+	for _, name := range names {
+		s := New().newSession(handlers[name])
+		ht := prefixT{tt: t, prefix: fmt.Sprintf("Handler %q: ", name)}
+		for i := range triggers {
+			ex := s.run(ht, triggers[i])
+			if ex == nil {
+				continue
+			}
+			trigger := caseName(i, &triggers[i])
+			format, err := errorFormat(ex.body)
+			if err != nil {
+				ht.Errorf("Got error response body %q for %s, expected a JSON object: %s", string(ex.body), trigger, err)
+				continue
+			}
+			if ref == nil {
+				ref, refName = format, fmt.Sprintf("handler %q (%s)", name, trigger)
+				continue
+			}
+			for _, k := range sortedFormatKeys(ref, format) {
+				switch exp, act := ref[k], format[k]; {
+				case act == "":
+					ht.Errorf("Deviates from the error format of %s for %s: missing key %q", refName, trigger, k)
+				case exp == "":
+					ht.Errorf("Deviates from the error format of %s for %s: unexpected key %q", refName, trigger, k)
+				case exp != act:
+					ht.Errorf("Deviates from the error format of %s for %s: key %q is %s, expected %s", refName, trigger, k, act, exp)
+				}
+			}
+		}
+		s.close()
+	}
+}
+
+// errorFormat decodes b as a JSON object, and returns the JSON type of the
+// value of every key.
+func errorFormat(b []byte) (map[string]string, error) {
+	var obj map[string]interface{}
+	if err := json.Unmarshal(b, &obj); err != nil {
+		return nil, err
+	}
+	if obj == nil {
+		return nil, fmt.Errorf("got null")
+	}
+	format := make(map[string]string, len(obj))
+	for k, v := range obj {
+		format[k] = jsonType(v)
+	}
+	return format, nil
+}
+
+// sortedFormatKeys returns the keys of both formats, sorted.
+func sortedFormatKeys(a, b map[string]string) []string {
+	var keys []string
+	for k := range a {
+		keys = append(keys, k)
+	}
+	for k := range b {
+		if _, ok := a[k]; !ok {
+			keys = append(keys, k)
+		}
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// jsonType returns the name of the JSON type of v, as decoded by
+// encoding/json.
+func jsonType(v interface{}) string {
+	switch v.(type) {
+	case map[string]interface{}:
+		return "an object"
+	case []interface{}:
+		return "an array"
+	case string:
+		return "a string"
+	case float64:
+		return "a number"
+	case bool:
+		return "a boolean"
+	default:
+		return "null"
+	}
+}
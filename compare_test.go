@@ -0,0 +1,178 @@
+package handlertest
+
+import (
+	"net/http"
+	"reflect"
+	"testing"
+)
+
+func TestRunCompareHeaders(t *testing.T) {
+	bare := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Cache-Control", "no-store")
+		w.Header().Set("Content-Type", "text/plain")
+	})
+	middleware := func(f func(h http.Header)) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			f(w.Header())
+			bare(w, r)
+		})
+	}
+	get := TestCase{Request: Request{Method: http.MethodGet, URL: "/"}}
+	headers := []string{"cache-control", "X-Frame-Options"}
+
+	tt := []struct {
+		name string
+
+		wrapped http.Handler
+
+		expectErrors int
+	}{
+		{
+			name:    "Transparent",
+			wrapped: middleware(func(h http.Header) { h.Set("X-Request-Id", "42") }),
+		},
+		{
+			name:         "Added",
+			wrapped:      middleware(func(h http.Header) { h.Set("X-Frame-Options", "DENY") }),
+			expectErrors: 1,
+		},
+		{
+			name: "Altered",
+			wrapped: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				bare(w, r)
+				w.Header().Add("Cache-Control", "private")
+			}),
+			expectErrors: 1,
+		},
+		{
+			name: "Dropped",
+			wrapped: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				bare(w, r)
+				w.Header().Del("Cache-Control")
+			}),
+			expectErrors: 1,
+		},
+	}
+	for _, tc := range tt {
+		t.Run(tc.name, func(t *testing.T) {
+			var m mock
+			RunCompareHeaders(&m, bare, tc.wrapped, headers, get)
+			if len(m.errors) != tc.expectErrors {
+				t.Errorf("Got %q, expected %d errors", m.errors, tc.expectErrors)
+			}
+		})
+	}
+}
+
+func TestRunErrorFormatCheck(t *testing.T) {
+	handler := func(body string) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(http.StatusNotFound)
+			_, _ = w.Write([]byte(body))
+		})
+	}
+	triggers := []TestCase{{
+		Request:  Request{Method: http.MethodGet, URL: "/missing"},
+		Response: Response{Code: http.StatusNotFound},
+	}}
+
+	tt := []struct {
+		name string
+
+		deviating http.Handler
+
+		expectErrors []string
+	}{
+		{
+			name:      "Consistent",
+			deviating: handler(`{"error": "order not found", "code": 404}`),
+		},
+		{
+			name:      "Deviating",
+			deviating: handler(`{"message": "not found", "code": "404"}`),
+			expectErrors: []string{
+				`Handler "orders": Deviates from the error format of handler "accounts" (test case #0) for test case #0: key "code" is a string, expected a number`,
+				`Handler "orders": Deviates from the error format of handler "accounts" (test case #0) for test case #0: missing key "error"`,
+				`Handler "orders": Deviates from the error format of handler "accounts" (test case #0) for test case #0: unexpected key "message"`,
+			},
+		},
+		{
+			name:         "Not JSON",
+			deviating:    handler("404 page not found"),
+			expectErrors: []string{`Handler "orders": Got error response body "404 page not found" for test case #0, expected a JSON object: invalid character 'p' after top-level value`},
+		},
+	}
+	for _, tc := range tt {
+		t.Run(tc.name, func(t *testing.T) {
+			var m mock
+			RunErrorFormatCheck(&m, map[string]http.Handler{
+				"accounts": handler(`{"error": "account not found", "code": 404}`),
+				"orders":   tc.deviating,
+			}, triggers)
+			if !reflect.DeepEqual(m.errors, tc.expectErrors) {
+				t.Errorf("Got %q, expected %q", m.errors, tc.expectErrors)
+			}
+		})
+	}
+}
+
+func TestRunReference(t *testing.T) {
+	reference := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.Header().Set("X-Request-Id", "1")
+		_, _ = w.Write([]byte(`{"id": 1, "tags": ["a", "b"]}`))
+	})
+	get := TestCase{Request: Request{Method: http.MethodGet, URL: "/"}}
+
+	tt := []struct {
+		name string
+
+		h http.HandlerFunc
+
+		expectErrors []string
+	}{
+		{
+			name: "Equivalent",
+			h: func(w http.ResponseWriter, r *http.Request) {
+				w.Header().Set("Content-Type", "application/json")
+				w.Header().Set("X-Request-Id", "2")
+				_, _ = w.Write([]byte(`{"tags":["a","b"],"id":1}`))
+			},
+		},
+		{
+			name: "Diverging",
+			h: func(w http.ResponseWriter, r *http.Request) {
+				w.Header().Set("Content-Type", "text/plain")
+				w.Header().Set("Cache-Control", "no-store")
+				w.WriteHeader(http.StatusAccepted)
+				_, _ = w.Write([]byte(`{"id": 1, "tags": ["a", "c"]}`))
+			},
+			expectErrors: []string{
+				"Got response code 202, expected 200 as the reference",
+				`Got header Cache-Control ["no-store"], expected it to be absent as in the reference`,
+				`Got header Content-Type ["text/plain"], expected ["application/json"] as the reference`,
+				`Got response body diverging from the reference: $.tags[1]: got "c", expected "b"`,
+			},
+		},
+		{
+			name: "Not JSON",
+			h: func(w http.ResponseWriter, r *http.Request) {
+				w.Header().Set("Content-Type", "application/json")
+				_, _ = w.Write([]byte("not found"))
+			},
+			expectErrors: []string{
+				`Got response body diverging from the reference: line 1: got "not found", expected "{\"id\": 1, \"tags\": [\"a\", \"b\"]}"`,
+			},
+		},
+	}
+	for _, tc := range tt {
+		t.Run(tc.name, func(t *testing.T) {
+			var m mock
+			RunReference(&m, reference, tc.h, []string{"x-request-id"}, get)
+			if !reflect.DeepEqual(m.errors, tc.expectErrors) {
+				t.Errorf("Got %q, expected %q", m.errors, tc.expectErrors)
+			}
+		})
+	}
+}
@@ -0,0 +1,32 @@
+package handlertest
+
+import (
+	"math/rand"
+	"net/http"
+	"time"
+)
+
+type (
+	randKey struct{}
+	nowKey  struct{}
+)
+
+// Rand returns the random number generator seeded with the Request.Seed of
+// the test case r was created for. If no seed was set, such as for requests
+// not fired by this package, it returns a new generator seeded with the
+// current time. It never returns nil, so handlers can read all randomness
+// from it in production as well.
+func Rand(r *http.Request) *rand.Rand {
+	if rnd, ok := r.Context().Value(randKey{}).(*rand.Rand); ok {
+		return rnd
+	}
+	return rand.New(rand.NewSource(time.Now().UnixNano()))
+}
+
+// Now returns the fixed time set as the Request.Now of the test case r was
+// created for. If no time was set, such as for requests not fired by this
+// package, it returns the zero time.
+func Now(r *http.Request) time.Time {
+	now, _ := r.Context().Value(nowKey{}).(time.Time)
+	return now
+}
@@ -0,0 +1,139 @@
+package handlertest
+
+import (
+	"fmt"
+	"math/rand"
+	"net/http"
+	"reflect"
+	"testing"
+	"time"
+
+	"gopkg.in/yaml.v2"
+)
+
+func TestRand(t *testing.T) {
+	shuffle := func(rnd *rand.Rand) string {
+		a := []int{1, 2, 3, 4, 5}
+		rnd.Shuffle(len(a), func(i, j int) { a[i], a[j] = a[j], a[i] })
+		return fmt.Sprint(a)
+	}
+	h := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte(shuffle(Rand(r))))
+	})
+	seed := func(n int64) *int64 {
+		return &n
+	}
+
+	for _, n := range []int64{42, 0} {
+		t.Run(fmt.Sprintf("Seeded with %d", n), func(t *testing.T) {
+			var m mock
+			tc := TestCase{
+				Request:  Request{Method: http.MethodGet, URL: "/shuffle", Seed: seed(n)},
+				Response: Response{Body: shuffle(rand.New(rand.NewSource(n)))},
+			}
+			Run(&m, h, tc, tc)
+
+			if m.errored {
+				t.Errorf("Got %q, expected no errors", m.errors)
+			}
+		})
+	}
+
+	t.Run("Not seeded", func(t *testing.T) {
+		var m mock
+		Run(&m, h, TestCase{
+			Request:  Request{Method: http.MethodGet, URL: "/shuffle"},
+			Response: Response{BodyRegexp: `^\[\d \d \d \d \d\]$`},
+		})
+
+		if m.errored {
+			t.Errorf("Got %q, expected no errors", m.errors)
+		}
+	})
+
+	t.Run("Seeder called", func(t *testing.T) {
+		var got int64 = -1
+		var m mock
+		New(WithSeeder(func(n int64) { got = n })).Run(&m, emptyHandler, TestCase{
+			Request: Request{Method: http.MethodGet, URL: "/", Seed: seed(0)},
+		})
+
+		if got != 0 {
+			t.Errorf("Got %d, expected 0", got)
+		}
+	})
+
+	t.Run("From YAML", func(t *testing.T) {
+		var req Request
+		if err := yaml.Unmarshal([]byte("seed: 0"), &req); err != nil {
+			t.Fatalf("Got %s, expected nil", err)
+		}
+		if req.Seed == nil || *req.Seed != 0 {
+			t.Errorf("Got %v, expected a seed of 0", req.Seed)
+		}
+	})
+}
+
+func TestNow(t *testing.T) {
+	now := time.Date(2020, 2, 29, 12, 0, 0, 0, time.UTC)
+	h := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		exp := Now(r).Add(time.Hour)
+		_, _ = fmt.Fprintf(w, `{"expires": %q}`, exp.Format(time.RFC3339))
+	})
+
+	tt := []struct {
+		name string
+
+		now time.Time
+
+		expectErrors []string
+	}{
+		{
+			name: "Fixed",
+			now:  now,
+		},
+		{
+			name:         "Different time",
+			now:          now.Add(time.Minute),
+			expectErrors: []string{`Got response body "{\"expires\": \"2020-02-29T13:01:00Z\"}", expected "{\"expires\": \"2020-02-29T13:00:00Z\"}"`},
+		},
+		{
+			name:         "Not set",
+			expectErrors: []string{`Got response body "{\"expires\": \"0001-01-01T01:00:00Z\"}", expected "{\"expires\": \"2020-02-29T13:00:00Z\"}"`},
+		},
+	}
+	for _, tc := range tt {
+		t.Run(tc.name, func(t *testing.T) {
+			var m mock
+			Run(&m, h, TestCase{
+				Request:  Request{Method: http.MethodGet, URL: "/token", Now: tc.now},
+				Response: Response{Body: `{"expires": "2020-02-29T13:00:00Z"}`},
+			})
+			if !reflect.DeepEqual(m.errors, tc.expectErrors) {
+				t.Errorf("Got %q, expected %q", m.errors, tc.expectErrors)
+			}
+		})
+	}
+
+	t.Run("Clock called", func(t *testing.T) {
+		var got time.Time
+		var m mock
+		New(WithClock(func(now time.Time) { got = now })).Run(&m, emptyHandler, TestCase{
+			Request: Request{Method: http.MethodGet, URL: "/", Now: now},
+		})
+
+		if !got.Equal(now) {
+			t.Errorf("Got %s, expected %s", got, now)
+		}
+	})
+
+	t.Run("From YAML", func(t *testing.T) {
+		var tc TestCase
+		if err := yaml.Unmarshal([]byte("request:\n  now: 2020-02-29T12:00:00Z\n"), &tc); err != nil {
+			t.Fatalf("Got %s, expected nil", err)
+		}
+		if !tc.Request.Now.Equal(now) {
+			t.Errorf("Got %s, expected %s", tc.Request.Now, now)
+		}
+	})
+}
@@ -0,0 +1,81 @@
+package handlertest
+
+import (
+	"net/http"
+	"strings"
+)
+
+// Cookie describes a cookie the handler is expected to set. Fields other than
+// Name that are not set are not asserted.
+type Cookie struct {
+	Name  string
+	Value string
+	// Path and Domain are the expected attributes of the same name.
+	Path   string
+	Domain string
+	// MaxAge is the expected Max-Age attribute. As with http.Cookie, a
+	// negative value means the cookie is deleted, e.g. on logout.
+	MaxAge *int
+	// Secure and HttpOnly are the expected presence of these attributes.
+	Secure   *bool
+	HttpOnly *bool
+	// SameSite is the expected SameSite attribute: Strict, Lax or None, in
+	// any case.
+	SameSite string
+}
+
+func assertCookies(t tt, ex *exchange, res *Response) {
+	if len(res.Cookies) == 0 {
+		return
+	}
+	set := make(map[string]*http.Cookie)
+	for _, c := range ex.rec.Result().Cookies() {
+		set[c.Name] = c
+	}
+
+	for _, exp := range res.Cookies {
+		c, ok := set[exp.Name]
+		if !ok {
+			t.Errorf("Missing cookie %q", exp.Name)
+			continue
+		}
+		for _, attr := range []struct {
+			name     string
+			act, exp string
+		}{
+			{"value", c.Value, exp.Value},
+			{"Path", c.Path, exp.Path},
+			{"Domain", c.Domain, exp.Domain},
+		} {
+			if attr.exp != "" && attr.act != attr.exp {
+				t.Errorf("Got cookie %q %s %q, expected %q", exp.Name, attr.name, attr.act, attr.exp)
+			}
+		}
+		if exp.MaxAge != nil && c.MaxAge != *exp.MaxAge {
+			t.Errorf("Got cookie %q Max-Age %d, expected %d", exp.Name, c.MaxAge, *exp.MaxAge)
+		}
+		if exp.Secure != nil && c.Secure != *exp.Secure {
+			t.Errorf("Got cookie %q Secure %t, expected %t", exp.Name, c.Secure, *exp.Secure)
+		}
+		if exp.HttpOnly != nil && c.HttpOnly != *exp.HttpOnly {
+			t.Errorf("Got cookie %q HttpOnly %t, expected %t", exp.Name, c.HttpOnly, *exp.HttpOnly)
+		}
+		if act := sameSite(c.SameSite); exp.SameSite != "" && !strings.EqualFold(act, exp.SameSite) {
+			t.Errorf("Got cookie %q SameSite %q, expected %q", exp.Name, act, exp.SameSite)
+		}
+	}
+}
+
+// sameSite returns the SameSite attribute for mode, or an empty string if it
+// was not set.
+func sameSite(mode http.SameSite) string {
+	switch mode {
+	case http.SameSiteStrictMode:
+		return "Strict"
+	case http.SameSiteLaxMode:
+		return "Lax"
+	case http.SameSiteNoneMode:
+		return "None"
+	}
+	return ""
+}
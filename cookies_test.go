@@ -0,0 +1,96 @@
+package handlertest
+
+import (
+	"net/http"
+	"reflect"
+	"testing"
+
+	"gopkg.in/yaml.v2"
+)
+
+func TestAssertCookies(t *testing.T) {
+	h := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.SetCookie(w, &http.Cookie{
+			Name:     "session",
+			Value:    "abc123",
+			Path:     "/",
+			Domain:   "example.com",
+			MaxAge:   3600,
+			Secure:   true,
+			HttpOnly: true,
+			SameSite: http.SameSiteLaxMode,
+		})
+		http.SetCookie(w, &http.Cookie{Name: "remember", MaxAge: -1})
+	})
+
+	tt := []struct {
+		name string
+
+		yaml string
+
+		expectErrors []string
+	}{
+		{
+			name: "Matching",
+			yaml: `
+- name: session
+  value: abc123
+  path: /
+  domain: example.com
+  maxage: 3600
+  secure: true
+  httponly: true
+  samesite: lax
+- name: remember
+  maxage: -1
+  secure: false
+`,
+		},
+		{
+			name: "Differing attributes",
+			yaml: `
+- name: session
+  value: def456
+  path: /app
+  maxage: 60
+  secure: false
+  httponly: false
+  samesite: Strict
+- name: remember
+  samesite: None
+`,
+			expectErrors: []string{
+				`Got cookie "session" value "abc123", expected "def456"`,
+				`Got cookie "session" Path "/", expected "/app"`,
+				`Got cookie "session" Max-Age 3600, expected 60`,
+				`Got cookie "session" Secure true, expected false`,
+				`Got cookie "session" HttpOnly true, expected false`,
+				`Got cookie "session" SameSite "Lax", expected "Strict"`,
+				`Got cookie "remember" SameSite "", expected "None"`,
+			},
+		},
+		{
+			name:         "Missing",
+			yaml:         "- name: csrf\n",
+			expectErrors: []string{`Missing cookie "csrf"`},
+		},
+	}
+	for _, tc := range tt {
+		t.Run(tc.name, func(t *testing.T) {
+			var cookies []Cookie
+			if err := yaml.Unmarshal([]byte(tc.yaml), &cookies); err != nil {
+				t.Fatalf("Got %s, expected nil", err)
+			}
+
+			var m mock
+			rec, err := record(h, &Request{Method: http.MethodPost, URL: "/login"})
+			if err != nil {
+				t.Fatalf("Got %s, expected nil", err)
+			}
+			assertCookies(&m, &exchange{rec: rec}, &Response{Cookies: cookies})
+			if !reflect.DeepEqual(m.errors, tc.expectErrors) {
+				t.Errorf("Got %q, expected %q", m.errors, tc.expectErrors)
+			}
+		})
+	}
+}
@@ -0,0 +1,53 @@
+package handlertest
+
+import "net/http"
+
+// AssertCORSOrigins fires two CORS preflight requests for url at h: one from
+// publicOrigin, which is expected to be allowed without credentials, e.g.
+// through the wildcard origin "*", and one from trustedOrigin, which is
+// expected to be allowed with credentials. As browsers reject the wildcard
+// origin on credentialed requests, the latter must echo the origin exactly,
+// and list Origin in its Vary header so caches do not serve it to other
+// origins. The combination of the wildcard origin with credentials is flagged
+// for both.
+func AssertCORSOrigins(t tt, h http.Handler, url, publicOrigin, trustedOrigin string) {
+	for _, origin := range []string{publicOrigin, trustedOrigin} {
+		rec, err := record(h, &Request{
+			Method: http.MethodOptions,
+			URL:    url,
+			Headers: []string{
+				"Origin: " + origin,
+				"Access-Control-Request-Method: " + http.MethodGet,
+			},
+		})
+		if err != nil {
+			t.Errorf("Cannot fire preflight request from %s: %s", origin, err)
+			return
+		}
+
+		hdr := rec.Result().Header
+		allowOrigin := hdr.Get("Access-Control-Allow-Origin")
+		credentials := hdr.Get("Access-Control-Allow-Credentials") == "true"
+		switch {
+		case allowOrigin == "*" && credentials:
+			t.Errorf("Got Access-Control-Allow-Origin * with Access-Control-Allow-Credentials true for %s, which browsers reject", origin)
+		case origin == publicOrigin:
+			if allowOrigin != "*" && allowOrigin != origin {
+				t.Errorf("Got Access-Control-Allow-Origin %q for %s, expected * or the origin", allowOrigin, origin)
+			}
+			if credentials {
+				t.Errorf("Got Access-Control-Allow-Credentials true for %s, expected the public origin not to be allowed credentials", origin)
+			}
+		default:
+			if allowOrigin != origin {
+				t.Errorf("Got Access-Control-Allow-Origin %q for %s, expected the origin", allowOrigin, origin)
+			}
+			if !credentials {
+				t.Errorf("Got Access-Control-Allow-Credentials %q for %s, expected true", hdr.Get("Access-Control-Allow-Credentials"), origin)
+			}
+			if !varies(hdr, "Origin") {
+				t.Errorf("Got Vary %q for %s, expected it to include Origin as the response varies by it", hdr.Get("Vary"), origin)
+			}
+		}
+	}
+}
@@ -0,0 +1,64 @@
+package handlertest
+
+import (
+	"net/http"
+	"reflect"
+	"testing"
+)
+
+func TestAssertCORSOrigins(t *testing.T) {
+	const public, trusted = "https://example.com", "https://app.example.com"
+	h := func(trustedOrigin, trustedCredentials, vary string) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if vary != "" {
+				w.Header().Set("Vary", vary)
+			}
+			if r.Header.Get("Origin") == trusted {
+				w.Header().Set("Access-Control-Allow-Origin", trustedOrigin)
+				w.Header().Set("Access-Control-Allow-Credentials", trustedCredentials)
+				return
+			}
+			w.Header().Set("Access-Control-Allow-Origin", "*")
+		})
+	}
+
+	tt := []struct {
+		name string
+
+		h http.Handler
+
+		expectErrors []string
+	}{
+		{
+			name: "Valid",
+			h:    h(trusted, "true", "Origin"),
+		},
+		{
+			name:         "Wildcard with credentials",
+			h:            h("*", "true", "Origin"),
+			expectErrors: []string{"Got Access-Control-Allow-Origin * with Access-Control-Allow-Credentials true for https://app.example.com, which browsers reject"},
+		},
+		{
+			name: "Without credentials and Vary",
+			h:    h(trusted, "", ""),
+			expectErrors: []string{
+				`Got Access-Control-Allow-Credentials "" for https://app.example.com, expected true`,
+				`Got Vary "" for https://app.example.com, expected it to include Origin as the response varies by it`,
+			},
+		},
+		{
+			name:         "Wrong origin",
+			h:            h(public, "true", "Origin"),
+			expectErrors: []string{`Got Access-Control-Allow-Origin "https://example.com" for https://app.example.com, expected the origin`},
+		},
+	}
+	for _, tc := range tt {
+		t.Run(tc.name, func(t *testing.T) {
+			var m mock
+			AssertCORSOrigins(&m, tc.h, "/api", public, trusted)
+			if !reflect.DeepEqual(m.errors, tc.expectErrors) {
+				t.Errorf("Got %q, expected %q", m.errors, tc.expectErrors)
+			}
+		})
+	}
+}
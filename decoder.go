@@ -0,0 +1,84 @@
+package handlertest
+
+import (
+	"fmt"
+	"mime"
+	"sync"
+)
+
+// Decoder decodes a response body into a generic value, such as the
+// map[string]interface{} encoding/json produces for objects.
+type Decoder func(b []byte) (interface{}, error)
+
+var (
+	decodersMu sync.RWMutex
+	decoders   = map[string]Decoder{
+		"application/json": decodeJSON,
+	}
+)
+
+// RegisterDecoder registers dec for responses with the media type mediaType,
+// e.g. "application/msgpack". When a test case sets Response.BodyValue, the
+// decoder for the media type of the response's Content-Type is used to decode
+// the body for comparison. A decoder for application/json is registered by
+// default. Registering a decoder for a media type replaces the existing one.
+func RegisterDecoder(mediaType string, dec Decoder) {
+	decodersMu.Lock()
+	defer decodersMu.Unlock()
+	decoders[mediaType] = dec
+}
+
+// decoderFor returns the decoder registered for the media type of the
+// Content-Type ct.
+func decoderFor(ct string) (Decoder, error) {
+	mt, _, err := mime.ParseMediaType(ct)
+	if err != nil {
+		return nil, fmt.Errorf("invalid Content-Type %q: %s", ct, err)
+	}
+
+	decodersMu.RLock()
+	defer decodersMu.RUnlock()
+	dec, ok := decoders[mt]
+	if !ok {
+		return nil, fmt.Errorf("no decoder registered for media type %q", mt)
+	}
+	return dec, nil
+}
+
+func assertBodyValue(t tt, ex *exchange, res *Response) {
+	if res.BodyValue == nil {
+		return
+	}
+	dec, err := decoderFor(ex.rec.Result().Header.Get("Content-Type"))
+	if err != nil {
+		t.Errorf("Cannot decode response body: %s", err)
+		return
+	}
+	v, err := dec(ex.body)
+	if err != nil {
+		t.Errorf("Cannot decode response body: %s", err)
+		return
+	}
+
+	// Normalize both values, so e.g. integers compare equal to the floats
+	// encoding/json decodes numbers into.
+	act, err := normalizeJSON(v)
+	if err != nil {
+		t.Errorf("Cannot compare decoded response body: %s", err)
+		return
+	}
+	exp, err := normalizeJSON(res.BodyValue)
+	if err != nil {
+		t.Errorf("Invalid expected body value: %s", err)
+		return
+	}
+	if err := removeJSON(exp, res.JSONIgnoreFields); err != nil {
+		t.Errorf("Invalid ignored JSON field: %s", err)
+		return
+	}
+	_ = removeJSON(act, res.JSONIgnoreFields)
+	d := res.jsonDiffer()
+	for _, diff := range d.diff(exp, act, nil) {
+		t.Errorf("Got unexpected response body value: %s", diff)
+	}
+}
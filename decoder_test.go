@@ -0,0 +1,84 @@
+package handlertest
+
+import (
+	"bytes"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestAssertBodyValue(t *testing.T) {
+	// A trivial format of comma-separated key=value pairs.
+	RegisterDecoder("application/x-pairs", func(b []byte) (interface{}, error) {
+		m := make(map[string]interface{})
+		for _, pair := range strings.Split(string(b), ",") {
+			split := strings.SplitN(pair, "=", 2)
+			if len(split) != 2 {
+				return nil, errors.New("invalid pair")
+			}
+			m[split[0]] = split[1]
+		}
+		return m, nil
+	})
+
+	tt := []struct {
+		name string
+
+		ct   string
+		body string
+		exp  interface{}
+
+		expectErrors int
+	}{
+		{
+			name: "JSON",
+			ct:   "application/json; charset=utf-8",
+			body: `{"b": [1, 2], "a": "foo"}`,
+			exp:  map[string]interface{}{"a": "foo", "b": []int{1, 2}},
+		},
+		{
+			name:         "JSON mismatch",
+			ct:           "application/json",
+			body:         `{"a": "bar", "b": [1, 2]}`,
+			exp:          map[string]interface{}{"a": "foo", "b": []int{1, 2}},
+			expectErrors: 1,
+		},
+		{
+			name: "Registered decoder",
+			ct:   "application/x-pairs",
+			body: "a=foo,b=bar",
+			exp:  map[interface{}]interface{}{"a": "foo", "b": "bar"},
+		},
+		{
+			name:         "Registered decoder fails",
+			ct:           "application/x-pairs",
+			body:         "a",
+			exp:          map[string]interface{}{},
+			expectErrors: 1,
+		},
+		{
+			name:         "No decoder",
+			ct:           "application/octet-stream",
+			body:         "a=foo",
+			exp:          map[string]interface{}{},
+			expectErrors: 1,
+		},
+	}
+	for _, tc := range tt {
+		t.Run(tc.name, func(t *testing.T) {
+			rec := &httptest.ResponseRecorder{
+				Code:      http.StatusOK,
+				HeaderMap: http.Header{"Content-Type": {tc.ct}},
+				Body:      bytes.NewBufferString(tc.body),
+			}
+
+			var m mock
+			New().assertResponse(&m, &exchange{rec: rec}, &Response{BodyValue: tc.exp})
+			if len(m.errors) != tc.expectErrors {
+				t.Errorf("Got %q, expected %d errors", m.errors, tc.expectErrors)
+			}
+		})
+	}
+}
@@ -0,0 +1,59 @@
+package handlertest
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+)
+
+// UpdateEnv is the environment variable that enables updating golden files,
+// as WithUpdate does.
+const UpdateEnv = "HANDLERTEST_UPDATE"
+
+// updating reports whether golden files are updated rather than asserted.
+func (r *Runner) updating() bool {
+	return r.update || os.Getenv(UpdateEnv) != ""
+}
+
+// updateBodyFile writes body to the golden file at path, creating its
+// directory if needed.
+func updateBodyFile(path string, body []byte) error {
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+	return ioutil.WriteFile(path, body, 0644)
+}
+
+// resolvePaths resolves the relative file paths of tcs, including those of
+// their Versions, against dir. These are BodyFile and JSONSchema.
+func resolvePaths(tcs []TestCase, dir string) {
+	resolve := func(res *Response) {
+		for _, p := range []*string{&res.BodyFile, &res.JSONSchema} {
+			if *p != "" && !filepath.IsAbs(*p) {
+				*p = filepath.Join(dir, *p)
+			}
+		}
+	}
+	for i := range tcs {
+		resolve(&tcs[i].Response)
+		for v, res := range tcs[i].Versions {
+			resolve(&res)
+			tcs[i].Versions[v] = res
+		}
+	}
+}
+
+func assertBodyFile(t tt, ex *exchange, res *Response) {
+	if res.BodyFile == "" {
+		return
+	}
+	b, err := ioutil.ReadFile(res.BodyFile)
+	if err != nil {
+		t.Errorf("Cannot read body file: %s", err)
+		return
+	}
+	body, expBody := res.normalizeBodies(string(ex.body), string(ex.expectedBody(b)))
+	if body != expBody {
+		t.Errorf("Got response body differing from %s: %s", res.BodyFile, diffText(body, expBody))
+	}
+}
@@ -0,0 +1,135 @@
+package handlertest
+
+import (
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestBodyFile(t *testing.T) {
+	dir, err := ioutil.TempDir("", "handlertest")
+	if err != nil {
+		t.Fatalf("Got %s, expected nil", err)
+	}
+	defer os.RemoveAll(dir)
+
+	files := map[string]string{
+		"users.yaml": `- name: "List users"
+  request:
+    method: GET
+    url: /users
+  response:
+    bodyfile: golden/users.json
+- name: "List users by version"
+  request:
+    method: GET
+    url: /users
+  versions:
+    v1:
+      bodyfile: golden/users.json
+`,
+		"golden/users.json": "[\n  {\"name\": \"Alice\"}\n]\n",
+	}
+	for name, content := range files {
+		path := filepath.Join(dir, name)
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatalf("Got %s, expected nil", err)
+		}
+		if err := ioutil.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatalf("Got %s, expected nil", err)
+		}
+	}
+	h := func(body string) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			_, _ = w.Write([]byte(body))
+		})
+	}
+
+	t.Run("Relative to YAML file", func(t *testing.T) {
+		var m mock
+		m.runFunc = t.Run
+		RunFromYAML(&m, h(files["golden/users.json"]), filepath.Join(dir, "users.yaml"))
+	})
+
+	tt := []struct {
+		name string
+
+		body     string
+		bodyFile string
+
+		expectErrors []string
+	}{
+		{
+			name:     "Matching",
+			body:     files["golden/users.json"],
+			bodyFile: filepath.Join(dir, "golden/users.json"),
+		},
+		{
+			name:     "Differing",
+			body:     "[\n  {\"name\": \"Bob\"}\n]\n",
+			bodyFile: filepath.Join(dir, "golden/users.json"),
+			expectErrors: []string{
+				"Got response body differing from " + filepath.Join(dir, "golden/users.json") + `: line 2: got "  {\"name\": \"Bob\"}", expected "  {\"name\": \"Alice\"}"`,
+			},
+		},
+		{
+			name:     "Missing file",
+			bodyFile: filepath.Join(dir, "golden/missing.json"),
+			expectErrors: []string{
+				"Cannot read body file: open " + filepath.Join(dir, "golden/missing.json") + ": no such file or directory",
+			},
+		},
+	}
+	for _, tc := range tt {
+		t.Run(tc.name, func(t *testing.T) {
+			var m mock
+			assertBodyFile(&m, &exchange{body: []byte(tc.body)}, &Response{BodyFile: tc.bodyFile})
+			if !reflect.DeepEqual(m.errors, tc.expectErrors) {
+				t.Errorf("Got %q, expected %q", m.errors, tc.expectErrors)
+			}
+		})
+	}
+}
+
+func TestWithUpdate(t *testing.T) {
+	dir, err := ioutil.TempDir("", "handlertest")
+	if err != nil {
+		t.Fatalf("Got %s, expected nil", err)
+	}
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "golden", "health.txt")
+	h := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusTeapot)
+		_, _ = w.Write([]byte("ok\n"))
+	})
+	tc := TestCase{
+		Request:  Request{Method: http.MethodGet, URL: "/health"},
+		Response: Response{BodyFile: path},
+	}
+
+	var m mock
+	New(WithUpdate()).Run(&m, h, tc)
+	expErrors := []string{"Got response code 418, expected 200"}
+	if !reflect.DeepEqual(m.errors, expErrors) {
+		t.Errorf("Got %q, expected %q", m.errors, expErrors)
+	}
+	expLogs := []string{"handlertest: updated " + path}
+	if !reflect.DeepEqual(m.logs, expLogs) {
+		t.Errorf("Got %q, expected %q", m.logs, expLogs)
+	}
+	if b, err := ioutil.ReadFile(path); err != nil || string(b) != "ok\n" {
+		t.Errorf("Got %q and %v, expected %q and nil", b, err, "ok\n")
+	}
+
+	// Without updating, the golden file now matches.
+	tc.Response.Code = http.StatusTeapot
+	m = mock{}
+	Run(&m, h, tc)
+	if m.errored || len(m.logs) != 0 {
+		t.Errorf("Got %q and %q, expected no errors or logs", m.errors, m.logs)
+	}
+}
@@ -3,15 +3,23 @@
 package handlertest
 
 import (
+	"context"
 	"fmt"
 	"io"
 	"io/ioutil"
+	"math/rand"
 	"net/http"
 	"net/http/httptest"
+	"net/url"
 	"os"
+	"path/filepath"
 	"reflect"
+	"sort"
+	"strconv"
 	"strings"
+	"sync"
 	"testing"
+	"time"
 
 	"gopkg.in/yaml.v2"
 )
@@ -19,6 +27,7 @@ import (
 type tt interface {
 	Errorf(format string, args ...interface{})
 	Fatalf(format string, args ...interface{})
+	Logf(format string, args ...interface{})
 	Run(name string, f func(t *testing.T)) bool
 }
 
@@ -31,23 +40,409 @@ type TestCase struct {
 	Name     string
 	Request  Request
 	Response Response
+	// ExpectFunc optionally computes the expected response from Request. Any
+	// non-zero field of the computed response takes precedence over the same
+	// field in Response. It can only be set from code.
+	ExpectFunc func(req Request) Response `yaml:"-"`
+	// ExpectLatency optionally repeats the request, and asserts a percentile
+	// of the response times.
+	ExpectLatency *Latency
+	// Versions maps API versions to the responses expected for each. If it
+	// is set, the test case runs once for every version, with the request
+	// directed at that version (see WithVersionFunc), and Response as the
+	// base of which non-zero fields of the version's response take
+	// precedence. Each run is named after its version.
+	Versions map[string]Response
+
+	// version is the version a test case expanded from Versions runs for.
+	version string
+}
+
+// UnmarshalYAML implements yaml.Unmarshaler. Next to the full structure, it
+// accepts a shorthand for simple cases: `GET /foo => 200`, optionally
+// followed by the expected body, as in `GET /health => 200 ok`. A code class
+// is accepted as well, as in `GET /foo => 2xx`. The shorthand itself is used
+// as the name of the test case.
+func (tc *TestCase) UnmarshalYAML(unmarshal func(interface{}) error) error {
+	var s string
+	if err := unmarshal(&s); err == nil {
+		return tc.parseShorthand(s)
+	}
+
+	// Prevent infinite recursion by unmarshaling into a type without the
+	// UnmarshalYAML method.
+	type plain TestCase
+	return unmarshal((*plain)(tc))
+}
+
+func (tc *TestCase) parseShorthand(s string) error {
+	invalid := fmt.Errorf("test case %q has invalid format (expected `METHOD URL => CODE [BODY]`)", s)
+	split := strings.SplitN(s, " => ", 2)
+	if len(split) != 2 {
+		return invalid
+	}
+	req, res := strings.Fields(split[0]), strings.SplitN(strings.TrimSpace(split[1]), " ", 2)
+	if len(req) != 2 {
+		return invalid
+	}
+	var expect Response
+	if _, ok := parseCodeClass(res[0]); ok {
+		expect.CodeClass = res[0]
+	} else {
+		code, err := strconv.Atoi(res[0])
+		if err != nil {
+			return invalid
+		}
+		expect.Code = code
+	}
+
+	*tc = TestCase{
+		Name:     s,
+		Request:  Request{Method: req[0], URL: req[1]},
+		Response: expect,
+	}
+	if len(res) == 2 {
+		tc.Response.Body = res[1]
+	}
+	return nil
 }
 
 // Request describes the request to fire at the HTTP handler.
 type Request struct {
-	Method  string
-	URL     string
+	Method string
+	// URL is sent as is. Percent-encoded path segments, e.g. an encoded
+	// slash (%2F), are preserved in the request's URL.RawPath, so routers
+	// that match on the escaped path see what a client would have sent.
+	URL string
+	// Query is added to the query of URL, after any parameters it already
+	// has. Keys may have multiple values, as in `?id=1&id=2`.
+	Query   url.Values
 	Body    string
 	Headers []string
+	// ContextValues are added to the request's context, as if placed there
+	// by middleware. As with context.WithValue, keys must be comparable and
+	// should be of a type defined by the package that reads them, so the
+	// handler's lookups match. It can only be set from code.
+	ContextValues map[interface{}]interface{} `yaml:"-"`
+	// Seed, if set, seeds a random number generator that the handler can
+	// obtain through Rand. The handler has to read all randomness from it
+	// for its response to be deterministic. It is a pointer, so that 0 is a
+	// valid seed.
+	Seed *int64
+	// Now, if set, is the fixed current time the handler can obtain through
+	// the package-level Now, or that is passed to the function registered
+	// with WithClock. The handler has to read the time from either for its
+	// response to be deterministic, e.g. to compare it to a golden body.
+	Now time.Time
+	// RequestTrailers are sent as trailers after the request body. Only a
+	// live server (see WithServer) delivers trailers as such, by sending the
+	// body chunked; when calling the handler directly, they are set on the
+	// request's Trailer up front.
+	RequestTrailers map[string]string
 }
 
 // Response describes the expected response from the HTTP handler. All fields
 // are optional: if they are not set, these are not asserted.
 type Response struct {
-	// Code is the expected HTTP status code.
+	// Code is the expected HTTP status code. If it is not set, 200 is
+	// expected, unless CodeAny or another status code assertion is set.
 	Code int
+	// CodeAny disables the implicit expectation of 200 when Code is not set,
+	// for test cases that only care about other aspects of the response.
+	CodeAny bool
+	// Codes are the acceptable status codes, for handlers that legitimately
+	// respond with any of these, e.g. 200 or 204. Unless Code is set as
+	// well, any of these is accepted, rather than only 200.
+	Codes []int
+	// CodeClass is the expected class of the status code, such as "2xx" for
+	// any success or "4xx" for any client error. Unless Code is set as well,
+	// any code in the class is accepted, rather than only 200.
+	CodeClass string
+	// RedirectTo asserts the response is a redirect (3xx) to this URL. The
+	// Location header matches if it refers to the same URL once both are
+	// resolved against the request URL, so "/login" matches
+	// "http://example.com/login" for a request to example.com. Unless Code
+	// is set as well, any redirect code is accepted, rather than only 200.
+	RedirectTo string
+	// NotCode is a status code the handler must not respond with. Unless
+	// Code is set as well, any other code is accepted, rather than only 200.
+	NotCode int
 	// Body is the expected response body.
 	Body string
+	// BodyBase64 and BodyHex are the expected body, encoded as standard
+	// base64 or hex, for binary bodies that cannot be expressed as a string
+	// in YAML. Whitespace is ignored, so long values can be wrapped.
+	BodyBase64 string
+	BodyHex    string
+	// EmptyBody asserts the body is empty, e.g. for 204 No Content. Body
+	// cannot express this, as its zero value means it is not asserted.
+	EmptyBody bool
+	// BodyFile is the path of a golden file holding the expected body, for
+	// large bodies that are unwieldy to inline. For test cases read from
+	// YAML, a relative path is resolved against the directory of the YAML
+	// file; otherwise against the working directory, which is the package
+	// directory under go test.
+	BodyFile string
+	// NotBody is a body the handler must not respond with.
+	NotBody string
+	// NormalizeNewlines converts CRLF line endings to LF in both the expected
+	// and the actual body before comparing them. Like NormalizeWhitespace, it
+	// applies to Body, BodyFile, NotBody, BodyContains, NotBodyContains and
+	// ExpectBodySuffix.
+	NormalizeNewlines bool
+	// NormalizeWhitespace trims leading and trailing whitespace, and
+	// collapses every other run of whitespace into a single space, in both
+	// the expected and the actual body before comparing them. This suits
+	// rendered templates, of which the whitespace differs between
+	// environments.
+	NormalizeWhitespace bool
+	// ExpectBodySuffix is the sequence the body must end with, such as the
+	// final newline of NDJSON, or the blank line that terminates the last
+	// event of a server-sent event stream ("\n\n"). This catches truncated
+	// streams.
+	ExpectBodySuffix string
+	// BodyContains are fragments the body must contain, anywhere and in any
+	// order, without pinning the entire body. In YAML, a single fragment can
+	// be given as a string rather than a list.
+	BodyContains StringList
+	// NotBodyContains are fragments the body must not contain, such as stack
+	// traces or secrets. Like BodyContains, it can be a single string in
+	// YAML.
+	NotBodyContains StringList
+	// BodyRegexp is a regular expression the body must match, e.g. for
+	// bodies with timestamps or generated identifiers. It is not anchored:
+	// use ^ and $ to match the entire body.
+	BodyRegexp string
+	// BodyRegexpGroups maps named capture groups of BodyRegexp, as in
+	// (?P<id>\d+), to the values these are expected to have captured.
+	BodyRegexpGroups map[string]string
+	// BodyRegexpNonEmpty lists named capture groups of BodyRegexp that are
+	// expected to have captured a value, whatever it is.
+	BodyRegexpNonEmpty []string
+	// BodyLengthBaseline is the expected body length in bytes, of which the
+	// actual length may deviate by BodyLengthTolerance, a fraction (e.g. 0.1
+	// for ±10%). This guards against large unexpected changes in size
+	// without pinning the content.
+	BodyLengthBaseline  int
+	BodyLengthTolerance float64
+	// BodyLength is the exact expected body length in bytes, e.g. for a page
+	// of fixed-size records. Use EmptyBody to assert an empty body.
+	BodyLength int
+	// MaxBodyBytes is the size in bytes the body must not exceed, e.g. to
+	// verify pagination limits.
+	MaxBodyBytes int
+	// BodyChecksum is the expected digest of the body, as "algorithm:hex",
+	// e.g. "sha256:9f86d0...", for large or binary bodies. Supported are md5,
+	// sha1, sha256 and sha512. Without an algorithm, sha256 is assumed. On a
+	// mismatch, the actual digest is reported, so it can be copied from a
+	// known-good run.
+	BodyChecksum string
+	// BodySHA256 is the expected hex-encoded SHA-256 digest of the body,
+	// e.g. for binary downloads. It is short for a BodyChecksum with the
+	// sha256 algorithm.
+	BodySHA256 string
+	// Headers are the expected response headers, in the same `Key: Value`
+	// format as Request.Headers. An entry without a value, e.g. `Allow`,
+	// only asserts that the header is present. For a header that is sent
+	// more than once, such as Link, one of its values must match.
+	Headers []string
+	// ContentType is the expected media type of the Content-Type header, such
+	// as application/json. Parameters such as charset are ignored, unlike
+	// when asserting the header through Headers.
+	ContentType string
+	// NotHeaders are the names of headers the response must not have, such
+	// as X-Powered-By or Server.
+	NotHeaders []string
+	// Trailers are the expected response trailers, in the same format as
+	// Headers. The handler must announce these in the Trailer header before
+	// writing the body, or set them with the http.TrailerPrefix.
+	Trailers []string
+	// Allow is the expected set of methods in the Allow header, in any order.
+	// A test case that expects 405 Method Not Allowed always asserts the
+	// Allow header is present and not empty, as the spec requires.
+	Allow []string
+	// CheckCharset asserts that the body can be decoded with the charset
+	// declared by the Content-Type header. Supported are utf-8, us-ascii and
+	// iso-8859-1.
+	CheckCharset bool
+	// CheckLastModified asserts that the Last-Modified header is present and
+	// holds a valid HTTP date.
+	CheckLastModified bool
+	// LastModifiedNotFuture additionally asserts that Last-Modified is not
+	// later than the time the response was received. It implies
+	// CheckLastModified.
+	LastModifiedNotFuture bool
+	// BodyValue is the expected body, decoded. The body is decoded with the
+	// Decoder registered for the response's Content-Type, and compared
+	// structurally. See RegisterDecoder.
+	BodyValue interface{}
+	// BodyJSON is the expected body as JSON text. Both it and the actual body
+	// are decoded and compared structurally, so the order of object keys and
+	// insignificant whitespace do not matter. Expected strings of the form
+	// "{match:name}" match any value the named Matcher accepts, in BodyJSON,
+	// BodyValue, BodyJSONArray and JSONPaths alike. See RegisterMatcher.
+	BodyJSON string
+	// JSONSubset allows objects in the JSON body to have keys that BodyJSON
+	// and BodyValue do not expect, at any depth, so that e.g. generated
+	// identifiers and timestamps can be left out. Arrays must still be of the
+	// expected length.
+	JSONSubset bool
+	// JSONIgnoreFields lists paths within the JSON body, such as
+	// "created_at" or "$.meta.request_id", that are left out when comparing
+	// BodyJSON and BodyValue, for fields that differ on every request. See
+	// Capture for the syntax of paths. Ignored array elements are compared
+	// as null, so the indexes of other elements are unchanged.
+	JSONIgnoreFields []string
+	// JSONSchema is the path of a JSON Schema file the JSON body must
+	// validate against, for contract-level assertions. Relative paths are
+	// resolved as for BodyFile. The validation keywords of draft 7 commonly
+	// used for contracts are supported: type, enum, const, properties,
+	// required, additionalProperties, items, minItems, maxItems, minLength,
+	// maxLength, pattern, minimum, maximum, exclusiveMinimum,
+	// exclusiveMaximum, allOf, anyOf, oneOf, not, and $ref to locations
+	// within the same file. Other keywords, such as format, are ignored.
+	JSONSchema string
+	// JSONPaths maps paths within the JSON body, such as "$.items[0].id",
+	// to their expected values, for asserting individual fields of large
+	// bodies. See Capture for the syntax of paths.
+	JSONPaths map[string]interface{}
+	// JSONPathsExist lists paths within the JSON body that are expected to
+	// exist, whatever their value, such as "$.error".
+	JSONPathsExist []string
+	// JSONEpsilon is the largest difference at which numbers in JSON bodies
+	// compare equal, e.g. 1e-9 for floating point aggregates.
+	JSONEpsilon float64
+	// JSONUnorderedArrays compares JSON arrays as sets, for handlers that
+	// return results in no particular order. Every expected element must
+	// match a distinct element of the actual array.
+	JSONUnorderedArrays bool
+	// JSONEmptyAsNull treats empty JSON arrays and objects as null when
+	// comparing BodyValue, BodyJSON, JSONPaths and BodyJSONArray, for
+	// serializers that emit either for empty collections. JSONEpsilon and
+	// JSONUnorderedArrays apply to these as well.
+	JSONEmptyAsNull bool
+	// Capture maps names to paths of values in the JSON response body, such
+	// as "data.token". Captured values can be referenced by the test cases
+	// that follow in the same run as {{.captured.name}}, in the request's
+	// URL, body and headers, and in the expected body and headers.
+	Capture map[string]string
+	// BodyForm asserts the body is form-encoded (as in
+	// application/x-www-form-urlencoded) and holds exactly these keys and
+	// values, in any order.
+	BodyForm map[string]string
+	// EchoBody computes the expected body from the request as the handler
+	// received it, for handlers that reflect (parts of) the request. It can
+	// only be set from code.
+	EchoBody func(r *http.Request) string `yaml:"-"`
+	// MaxDuration is the longest the handler may take to respond, e.g.
+	// 50ms, for catching latency regressions in a single run. See
+	// TestCase.ExpectLatency for asserting percentiles over repeated runs.
+	MaxDuration time.Duration
+	// Assert is called with the response after the other assertions, for
+	// checks the other fields cannot express. It runs as a subtest named
+	// Assert, so that it can report any number of failures on t and use
+	// test helpers. The body of the response holds the body as the other
+	// assertions see it, e.g. decompressed. It can only be set from code.
+	Assert func(t *testing.T, res *http.Response) `yaml:"-"`
+	// Decompress decodes the body according to the Content-Encoding header
+	// before asserting it. Supported are gzip and deflate. The encoding
+	// must be acceptable according to the request's Accept-Encoding.
+	Decompress bool
+	// Decrypt is applied to the body before asserting it, for handlers that
+	// respond with encrypted payloads. A failure to decrypt fails the test
+	// case. It can only be set from code.
+	Decrypt func(b []byte) ([]byte, error) `yaml:"-"`
+	// StripANSI removes ANSI escape sequences, such as terminal colors, from
+	// the body before asserting it, for handlers that serve colorized
+	// terminal output. Failures show the body as stripped.
+	StripANSI bool
+	// Validator is a command, as program and arguments, that receives the
+	// body on its standard input. If it exits with a non-zero status, the
+	// test case fails with its standard error output. As this executes
+	// arbitrary commands, only run fixtures from trusted sources.
+	Validator []string
+	// AccessControlMaxAge asserts the Access-Control-Max-Age header of a CORS
+	// preflight response is an integer within these bounds. For an exact
+	// value, set both Min and Max to it.
+	AccessControlMaxAge *Bounds
+	// ByteRanges asserts the response is multipart/byteranges, consisting
+	// of these parts in order. See MultiRangeRequest.
+	ByteRanges []ByteRange
+	// Cookies are the cookies the handler is expected to set through
+	// Set-Cookie headers. See Cookie.
+	Cookies []Cookie
+	// Problem asserts the response is an RFC 7807 Problem Details document
+	// (application/problem+json). Its status, if any, must match the
+	// response code.
+	Problem *Problem
+	// ErrorEnvelope asserts the response body is a JSON object with at least
+	// these top-level keys, e.g. "error" and "message". An empty, non-nil
+	// slice only asserts the body is a JSON object.
+	ErrorEnvelope []string
+	// BodyJSONArray asserts the response body is a JSON array of the same
+	// length, of which every element contains the fields of the element at
+	// the same index. Fields that are not expected are ignored.
+	BodyJSONArray []interface{}
+	// BodySorted asserts the order of the elements of a JSON array in the
+	// response body.
+	BodySorted *BodySorted
+	// ExpectKeyOrder maps paths of objects in the JSON response body, such as
+	// "data.user" or "" for the body itself, to the keys these objects are
+	// expected to have, in order, e.g. for canonical JSON that is signed.
+	// The order is checked on the raw body.
+	ExpectKeyOrder map[string][]string
+	// JSONArrayStream asserts the response body is a JSON array, decoding
+	// and checking one element at a time. It reports the index of the first
+	// element that fails.
+	JSONArrayStream *JSONArrayStream
+	// ExpectStruct is a struct, or a pointer to one, that the JSON response
+	// body is decoded into. Its non-zero fields are pinned: these must match
+	// the decoded body. Fields the body has but the struct lacks are
+	// ignored. It can only be set from code.
+	ExpectStruct interface{} `yaml:"-"`
+	// RequiredFields are the fields of ExpectStruct that must be non-zero
+	// after decoding the body, by their JSON names. Nested fields are
+	// separated by dots, e.g. "data.id".
+	RequiredFields []string
+	// ForbiddenBodyPatterns are regular expressions that must not match the
+	// body of an error response (status code 400 or higher), such as
+	// `goroutine \d+ \[` for stack traces, to catch handlers leaking
+	// internal details. See also WithForbiddenBodyPatterns.
+	ForbiddenBodyPatterns []string
+	// ExpectQuery asserts the values of these query parameters, as the
+	// handler saw them. Parameters that are not listed are ignored.
+	ExpectQuery url.Values
+	// ExpectHandlerSawTrailers asserts the request's Trailer held these
+	// values once the handler returned, which requires the handler to have
+	// consumed the request body. See Request.RequestTrailers.
+	ExpectHandlerSawTrailers map[string]string
+}
+
+// StringList is a list of strings that, in YAML, can also be given as a single
+// string, for lists that usually hold one element.
+type StringList []string
+
+// UnmarshalYAML implements yaml.Unmarshaler.
+func (l *StringList) UnmarshalYAML(unmarshal func(interface{}) error) error {
+	var s string
+	if err := unmarshal(&s); err == nil {
+		*l = StringList{s}
+		return nil
+	}
+	return unmarshal((*[]string)(l))
+}
+
+// BodySorted describes the order the elements of a JSON array in the response
+// body are expected to be in.
+type BodySorted struct {
+	// Path locates the array within the body, e.g. "data.items". If it is
+	// not set, the body itself is expected to be an array.
+	Path string
+	// Field is the key within each element to compare on. If it is not set,
+	// the elements themselves are compared.
+	Field string
+	// Order is either "asc" (default) or "desc".
+	Order string
 }
 
 // RunFromYAML reads a YAML serialized representation of TestCases from path
@@ -56,6 +451,12 @@ type Response struct {
 // stopped. If the response does not match the expectation, t is flagged as
 // failed with a descriptive error.
 func RunFromYAML(t tt, h http.Handler, path string) {
+	New().RunFromYAML(t, h, path)
+}
+
+// RunFromYAML is like the package-level RunFromYAML, but with the Runner's
+// options applied.
+func (r *Runner) RunFromYAML(t tt, h http.Handler, path string) {
 	f, err := os.Open(path)
 	if err != nil {
 		t.Fatalf("os: Open: %s", err)
@@ -65,11 +466,13 @@ func RunFromYAML(t tt, h http.Handler, path string) {
 		_ = f.Close()
 	}()
 
-	runFromYAML(t, h, f)
+	r.runFromYAML(t, h, f, filepath.Dir(path))
 }
 
-func runFromYAML(t tt, h http.Handler, r io.Reader) {
-	b, err := ioutil.ReadAll(r)
+// runFromYAML runs the test cases read from rd, of which relative paths are
+// resolved against dir.
+func (r *Runner) runFromYAML(t tt, h http.Handler, rd io.Reader, dir string) {
+	b, err := ioutil.ReadAll(rd)
 	if err != nil {
 		t.Fatalf("io/ioutil: ReadAll: %s", err)
 		return
@@ -80,27 +483,255 @@ func runFromYAML(t tt, h http.Handler, r io.Reader) {
 		t.Fatalf("yaml: Unmarshal: %s", err)
 		return
 	}
+	resolvePaths(tcs, dir)
 
-	Run(t, h, tcs...)
+	r.Run(t, h, tcs...)
 }
 
 // Run runs the test cases, tcs, against h. When the response does not match
-// the expectation, t is flagged as failed with a descriptive error.
+// the expectation, t is flagged as failed with a descriptive error. All
+// assertions of a test case are evaluated, so every failure is reported.
 func Run(t tt, h http.Handler, tcs ...TestCase) {
-	for _, tc := range tcs {
-		f := func(t tt) {
-			rec := httptest.NewRecorder()
-			req := httpRequest(&tc.Request)
-			h.ServeHTTP(rec, req)
-			assertResponse(t, rec, &tc.Response)
+	New().Run(t, h, tcs...)
+}
+
+// Run is like the package-level Run, but with the Runner's options applied.
+func (r *Runner) Run(t tt, h http.Handler, tcs ...TestCase) {
+	s := r.newSession(h)
+	defer s.close()
+
+	tcs = expandVersions(tcs)
+	var passed, failed, slow int
+	// invariants maps every invariant header to its values, and these to
+	// the test cases that responded with them.
+	invariants := make(map[string]map[string][]string)
+	for i, tc := range tcs {
+		runNamed(t, tc.Name, func(t tt) {
+			ft := &failureT{tt: t}
+			defer func() {
+				if ft.failed {
+					failed++
+				} else {
+					passed++
+				}
+			}()
+
+			ex := s.run(ft, tc)
+			if ex != nil {
+				for _, name := range r.invariantHeaders {
+					if invariants[name] == nil {
+						invariants[name] = make(map[string][]string)
+					}
+					v := ex.rec.Result().Header.Get(name)
+					invariants[name][v] = append(invariants[name][v], caseName(i, &tc))
+				}
+			}
+			if ex != nil && tc.ExpectLatency != nil {
+				s.assertLatency(ft, tc, ex.duration)
+			}
+			if ex != nil && r.slowThreshold > 0 && ex.duration > r.slowThreshold {
+				slow++
+				t.Logf("handlertest: %s took %s, exceeding slow threshold of %s", caseName(i, &tc), ex.duration, r.slowThreshold)
+			}
+		})
+	}
+
+	for _, name := range r.invariantHeaders {
+		if len(invariants[name]) < 2 {
+			continue
+		}
+		values := make([]string, 0, len(invariants[name]))
+		for v := range invariants[name] {
+			values = append(values, v)
+		}
+		sort.Strings(values)
+		for i, v := range values {
+			values[i] = fmt.Sprintf("%q from %s", v, strings.Join(invariants[name][v], ", "))
+		}
+		t.Errorf("Got differing %s headers across test cases: %s", name, strings.Join(values, "; "))
+	}
+
+	if r.summary {
+		// Cases that did not run, e.g. due to the -run flag, are skipped.
+		summary := fmt.Sprintf("handlertest: %d passed, %d failed, %d skipped", passed, failed, len(tcs)-passed-failed)
+		if slow > 0 {
+			summary += fmt.Sprintf(", %d slow", slow)
+		}
+		t.Logf("%s", summary)
+	}
+}
+
+// caseName identifies tc, the i-th test case of a run, in log messages.
+func caseName(i int, tc *TestCase) string {
+	if tc.Name != "" {
+		return fmt.Sprintf("%q", tc.Name)
+	}
+	return fmt.Sprintf("test case #%d", i)
+}
+
+// session holds the state shared by the test cases of a single run.
+type session struct {
+	r        *Runner
+	h        http.Handler
+	srv      *httptest.Server
+	client   *http.Client
+	captured map[string]string
+
+	mu       sync.Mutex
+	received *http.Request // By the live server.
+}
+
+func (r *Runner) newSession(h http.Handler) *session {
+	s := session{
+		r:        r,
+		h:        h,
+		captured: make(map[string]string),
+	}
+	if r.server {
+		s.srv = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+			defer func() {
+				// Once the handler returns, the server drains the body,
+				// which fills in the trailers the handler did not see.
+				received := req.WithContext(req.Context())
+				received.Trailer = req.Trailer.Clone()
+				s.mu.Lock()
+				s.received = received
+				s.mu.Unlock()
+			}()
+			h.ServeHTTP(w, req)
+		}))
+		s.client = newClient(s.srv)
+	}
+	return &s
+}
+
+func (s *session) close() {
+	if s.srv != nil {
+		s.srv.Close()
+	}
+}
+
+// run fires the request of tc and asserts the response. It returns the
+// exchange, or nil if the request could not be fired.
+func (s *session) run(t tt, tc TestCase) *exchange {
+	if err := resolveCaptured(&tc, s.captured); err != nil {
+		t.Errorf("Cannot run test case: %s", err)
+		return nil
+	}
+	if tc.Request.Seed != nil && s.r.seeder != nil {
+		s.r.seeder(*tc.Request.Seed)
+	}
+	if !tc.Request.Now.IsZero() && s.r.clock != nil {
+		s.r.clock(tc.Request.Now)
+	}
+	res := expectedResponse(&tc)
+	if len(s.r.forbiddenBodyPatterns) > 0 {
+		res.ForbiddenBodyPatterns = append(append([]string(nil), s.r.forbiddenBodyPatterns...), res.ForbiddenBodyPatterns...)
+	}
+	req := httpRequest(&tc.Request)
+	if tc.version != "" {
+		s.r.directVersion(req, tc.version)
+	}
+	for _, m := range s.r.mutators {
+		if m.matches(req) {
+			m.f(req)
+		}
+	}
+	rec := httptest.NewRecorder()
+	start := time.Now()
+	if s.srv != nil {
+		// The server may reject the request before the handler sees it, in
+		// which case there is no request to assert.
+		s.mu.Lock()
+		s.received = nil
+		s.mu.Unlock()
+		var err error
+		if rec, err = roundTrip(s.client, s.srv, req); err != nil {
+			t.Errorf("Cannot send request: %s", err)
+			return nil
 		}
+		s.mu.Lock()
+		req = s.received
+		s.mu.Unlock()
+	} else {
+		var w http.ResponseWriter = rec
+		var cw *closingWriter
+		if s.r.lateWrites {
+			cw = newClosingWriter(w)
+			defer cw.assertNoLateWrites(t, s.r.lateWriteGrace)
+			w = cw
+		}
+		var bw *bufferingWriter
+		if s.r.maxBuffered > 0 {
+			bw = &bufferingWriter{ResponseWriter: w, max: s.r.maxBuffered}
+			w = bw
+		}
+		p := serve(s.h, w, req)
+		if cw != nil {
+			cw.close()
+		}
+		if p != nil {
+			t.Errorf("Handler panicked: %v", p)
+			return nil
+		}
+		if bw != nil && bw.exceeded > 0 {
+			t.Errorf("Handler buffered %d bytes without flushing, expected at most %d", bw.exceeded, bw.max)
+		}
+	}
+	ex := &exchange{req: req, rec: rec, duration: time.Since(start)}
+	s.r.assertResponse(t, ex, &res)
+	capture(t, ex, &res, s.captured)
+	return ex
+}
+
+// runNamed runs f as a subtest of t if name is set, or directly on t
+// otherwise.
+func runNamed(t tt, name string, f func(t tt)) {
+	if name == "" {
+		f(t)
+		return
+	}
+	t.Run(name, func(t *testing.T) {
+		f(t)
+	})
+}
 
-		if tc.Name != "" {
-			t.Run(tc.Name, func(t *testing.T) {
-				f(t)
-			})
-		} else {
-			f(t)
+// serve calls h.ServeHTTP, and returns the value h panicked with, if any.
+func serve(h http.Handler, w http.ResponseWriter, req *http.Request) (p interface{}) {
+	defer func() {
+		p = recover()
+	}()
+	h.ServeHTTP(w, req)
+	return nil
+}
+
+// record fires req at h, and returns the recorded response. If h panics, an
+// error is returned instead.
+func record(h http.Handler, req *Request) (*httptest.ResponseRecorder, error) {
+	rec := httptest.NewRecorder()
+	if p := serve(h, rec, httpRequest(req)); p != nil {
+		return nil, fmt.Errorf("handler panicked: %v", p)
+	}
+	return rec, nil
+}
+
+// expectedResponse returns the response tc expects, taking ExpectFunc into
+// account.
+func expectedResponse(tc *TestCase) Response {
+	res := tc.Response
+	if tc.ExpectFunc != nil {
+		mergeResponse(&res, tc.ExpectFunc(tc.Request))
+	}
+	return res
+}
+
+// mergeResponse sets every field of res to the one of override, if that is
+// not zero.
+func mergeResponse(res *Response, override Response) {
+	dst, src := reflect.ValueOf(res).Elem(), reflect.ValueOf(override)
+	for i := 0; i < src.NumField(); i++ {
+		if !src.Field(i).IsZero() {
+			dst.Field(i).Set(src.Field(i))
 		}
 	}
 }
@@ -110,7 +741,15 @@ func httpRequest(req *Request) *http.Request {
 	if req.Body != "" {
 		body = strings.NewReader(req.Body)
 	}
-	httpreq := httptest.NewRequest(req.Method, req.URL, body)
+	target := req.URL
+	if len(req.Query) > 0 {
+		sep := "?"
+		if strings.Contains(target, "?") {
+			sep = "&"
+		}
+		target += sep + req.Query.Encode()
+	}
+	httpreq := httptest.NewRequest(req.Method, target, body)
 	for _, h := range req.Headers {
 		split := strings.SplitN(h, ": ", 2)
 		if len(split) != 2 {
@@ -119,22 +758,24 @@ func httpRequest(req *Request) *http.Request {
 		}
 		httpreq.Header.Set(split[0], split[1])
 	}
-	return httpreq
-}
-
-func assertResponse(t tt, rec *httptest.ResponseRecorder, res *Response) {
-	expCode := res.Code
-	if isZero(expCode) {
-		expCode = http.StatusOK
-	}
-	if rec.Code != expCode {
-		t.Errorf("Got response code %d, expected %d", rec.Code, expCode)
+	if len(req.RequestTrailers) > 0 {
+		httpreq.Trailer = make(http.Header, len(req.RequestTrailers))
+		for k, v := range req.RequestTrailers {
+			httpreq.Trailer.Set(k, v)
+		}
 	}
-	if s := rec.Body.String(); !isZero(res.Body) && s != res.Body {
-		t.Errorf("Got response body %q, expected %q", s, res.Body)
+	if len(req.ContextValues) > 0 || req.Seed != nil || !req.Now.IsZero() {
+		ctx := httpreq.Context()
+		for k, v := range req.ContextValues {
+			ctx = context.WithValue(ctx, k, v)
+		}
+		if req.Seed != nil {
+			ctx = context.WithValue(ctx, randKey{}, rand.New(rand.NewSource(*req.Seed)))
+		}
+		if !req.Now.IsZero() {
+			ctx = context.WithValue(ctx, nowKey{}, req.Now)
+		}
+		httpreq = httpreq.WithContext(ctx)
 	}
-}
-
-func isZero(i interface{}) bool {
-	return reflect.ValueOf(i).IsZero()
+	return httpreq
 }
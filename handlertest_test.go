@@ -1,12 +1,12 @@
 package handlertest
 
 import (
-	"bytes"
+	"fmt"
 	"io"
 	"io/ioutil"
 	"net/http"
-	"net/http/httptest"
 	"net/url"
+	"reflect"
 	"strings"
 	"testing"
 )
@@ -15,12 +15,22 @@ var emptyHandler = http.HandlerFunc(func(_ http.ResponseWriter, _ *http.Request)
 
 type mock struct {
 	errored bool
+	errors  []string
 	fataled bool
+	logs    []string
 	runFunc func(name string, f func(t *testing.T)) bool
 }
 
-func (m *mock) Errorf(format string, args ...interface{})  { m.errored = true }
-func (m *mock) Fatalf(format string, args ...interface{})  { m.fataled = true }
+func (m *mock) Errorf(format string, args ...interface{}) {
+	m.errored = true
+	m.errors = append(m.errors, fmt.Sprintf(format, args...))
+}
+func (m *mock) Fatalf(format string, args ...interface{}) { m.fataled = true }
+
+func (m *mock) Logf(format string, args ...interface{}) {
+	m.logs = append(m.logs, fmt.Sprintf(format, args...))
+}
+
 func (m *mock) Run(name string, f func(t *testing.T)) bool { return m.runFunc(name, f) }
 
 func TestRunFromYAML(t *testing.T) {
@@ -34,6 +44,87 @@ func TestRunFromYAML(t *testing.T) {
 	})
 }
 
+func TestRunFromYAMLShorthand(t *testing.T) {
+	h := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case r.URL.Path != "/health":
+			http.NotFound(w, r)
+		case r.Method != http.MethodGet:
+			w.Header().Set("Allow", "GET, HEAD")
+			w.WriteHeader(http.StatusMethodNotAllowed)
+		default:
+			_, _ = w.Write([]byte("ok"))
+		}
+	})
+
+	var names []string
+	m := mock{
+		runFunc: func(name string, f func(t *testing.T)) bool {
+			names = append(names, name)
+			return t.Run(name, f)
+		},
+	}
+	RunFromYAML(&m, h, "testdata/shorthand.yaml")
+
+	if m.fataled {
+		t.Fatalf("Got true, expected false")
+	}
+	exp := []string{"GET /health => 200 ok", "POST /health => 405", "Router returns not found on undefined URL"}
+	if !reflect.DeepEqual(names, exp) {
+		t.Errorf("Got %q, expected %q", names, exp)
+	}
+}
+
+func TestParseShorthand(t *testing.T) {
+	tt := []struct {
+		in string
+
+		expect      TestCase
+		expectError bool
+	}{
+		{
+			in: "GET /foo => 204",
+			expect: TestCase{
+				Name:     "GET /foo => 204",
+				Request:  Request{Method: http.MethodGet, URL: "/foo"},
+				Response: Response{Code: http.StatusNoContent},
+			},
+		},
+		{
+			in: "GET /foo?bar=baz => 200 Hello world!",
+			expect: TestCase{
+				Name:     "GET /foo?bar=baz => 200 Hello world!",
+				Request:  Request{Method: http.MethodGet, URL: "/foo?bar=baz"},
+				Response: Response{Code: http.StatusOK, Body: "Hello world!"},
+			},
+		},
+		{
+			in: "DELETE /foo => 4xx",
+			expect: TestCase{
+				Name:     "DELETE /foo => 4xx",
+				Request:  Request{Method: http.MethodDelete, URL: "/foo"},
+				Response: Response{CodeClass: "4xx"},
+			},
+		},
+		{in: "GET /foo", expectError: true},
+		{in: "GET /foo => 6xx", expectError: true},
+		{in: "/foo => 200", expectError: true},
+		{in: "GET /foo => ok", expectError: true},
+	}
+	for _, tc := range tt {
+		t.Run(tc.in, func(t *testing.T) {
+			var got TestCase
+			err := got.parseShorthand(tc.in)
+			if (err != nil) != tc.expectError {
+				t.Fatalf("Got error %v, expected error: %t", err, tc.expectError)
+			}
+			if !reflect.DeepEqual(got, tc.expect) {
+				t.Errorf("Got %+v, expected %+v", got, tc.expect)
+			}
+		})
+	}
+}
+
 func TestRun(t *testing.T) {
 	t.Run("With name: run called", func(t *testing.T) {
 		var actual string
@@ -157,6 +248,35 @@ func TestRun(t *testing.T) {
 	})
 }
 
+func TestExpectedResponse(t *testing.T) {
+	t.Run("Without ExpectFunc", func(t *testing.T) {
+		tc := TestCase{Response: Response{Code: http.StatusCreated, Body: "foo"}}
+
+		got := expectedResponse(&tc)
+		if got.Code != http.StatusCreated || got.Body != "foo" {
+			t.Errorf("Got %+v, expected %+v", got, tc.Response)
+		}
+	})
+
+	t.Run("Computed fields take precedence", func(t *testing.T) {
+		tc := TestCase{
+			Request:  Request{Body: "Hello world!"},
+			Response: Response{Code: http.StatusCreated, Body: "foo"},
+			ExpectFunc: func(req Request) Response {
+				return Response{Body: req.Body}
+			},
+		}
+
+		got := expectedResponse(&tc)
+		if got.Code != http.StatusCreated {
+			t.Errorf("Got %d, expected %d", got.Code, http.StatusCreated)
+		}
+		if got.Body != "Hello world!" {
+			t.Errorf("Got %q, expected %q", got.Body, "Hello world!")
+		}
+	})
+}
+
 func TestHTTPRequest(t *testing.T) {
 	tt := []struct {
 		name   string
@@ -223,6 +343,9 @@ func TestHTTPRequest(t *testing.T) {
 			if got.URL.String() != tc.expect.URL.String() {
 				t.Errorf("Got %s, expected %s", got.URL, tc.expect.URL)
 			}
+			if tc.in.Body == "" && got.Body != http.NoBody {
+				t.Errorf("Got %v, expected http.NoBody", got.Body)
+			}
 			gotBody, expBody := readAll(t, got.Body), readAll(t, tc.expect.Body)
 			if gotBody != expBody {
 				t.Errorf("Got %q, expected %q", gotBody, expBody)
@@ -249,6 +372,68 @@ func TestHTTPRequest(t *testing.T) {
 	}
 }
 
+func TestHTTPRequestEncodedPath(t *testing.T) {
+	// Routes /files/{name}, where name is a single, possibly encoded, path
+	// segment.
+	h := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		segments := strings.Split(strings.TrimPrefix(r.URL.EscapedPath(), "/"), "/")
+		if len(segments) != 2 || segments[0] != "files" {
+			http.NotFound(w, r)
+			return
+		}
+		name, err := url.PathUnescape(segments[1])
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		_, _ = w.Write([]byte(name))
+	})
+	tcs := []TestCase{
+		{
+			Request:  Request{Method: http.MethodGet, URL: "/files/a%2Fb"},
+			Response: Response{Code: http.StatusOK, Body: "a/b"},
+		},
+		{
+			Request:  Request{Method: http.MethodGet, URL: "/files/a/b"},
+			Response: Response{Code: http.StatusNotFound},
+		},
+	}
+
+	req := httpRequest(&tcs[0].Request)
+	if req.URL.RawPath != "/files/a%2Fb" {
+		t.Errorf("Got %q, expected /files/a%%2Fb", req.URL.RawPath)
+	}
+
+	t.Run("Recorder", func(t *testing.T) {
+		var m mock
+		Run(&m, h, tcs...)
+		if m.errored {
+			t.Errorf("Got %q, expected no errors", m.errors)
+		}
+	})
+
+	t.Run("Server", func(t *testing.T) {
+		var m mock
+		RunServer(&m, h, tcs...)
+		if m.errored {
+			t.Errorf("Got %q, expected no errors", m.errors)
+		}
+	})
+}
+
+func TestHTTPRequestContextValues(t *testing.T) {
+	type key struct{}
+
+	got := httpRequest(&Request{
+		Method:        http.MethodGet,
+		URL:           "/foo",
+		ContextValues: map[interface{}]interface{}{key{}: "tenant-42"},
+	})
+	if v := got.Context().Value(key{}); v != "tenant-42" {
+		t.Errorf("Got %v, expected tenant-42", v)
+	}
+}
+
 func mustParseURL(t *testing.T, s string) *url.URL {
 	t.Helper()
 
@@ -279,91 +464,59 @@ func readAll(t *testing.T, rc io.ReadCloser) string {
 	return string(b)
 }
 
-func TestAssertResponse(t *testing.T) {
+func TestRequestQuery(t *testing.T) {
+	h := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte(r.URL.RawQuery))
+	})
+
 	tt := []struct {
 		name string
 
-		m     mock
-		inRec *httptest.ResponseRecorder
-		inRes *Response
+		url   string
+		query url.Values
 
-		expectError bool
+		expectBody string
 	}{
 		{
-			name: "OK with body",
-			inRec: &httptest.ResponseRecorder{
-				Code: http.StatusInternalServerError,
-				Body: bytes.NewBufferString("Hello world!"),
-			},
-			inRes: &Response{
-				Code: http.StatusInternalServerError,
-				Body: "Hello world!",
-			},
+			name:       "Without query",
+			url:        "/items",
+			query:      url.Values{"id": {"1", "2"}},
+			expectBody: "id=1&id=2",
 		},
 		{
-			name:  "OK without body",
-			inRec: &httptest.ResponseRecorder{Code: http.StatusOK},
-			inRes: &Response{Code: http.StatusOK},
-		},
-		{
-			name: "Absent code and body",
-			inRec: &httptest.ResponseRecorder{
-				Code: http.StatusOK,
-				Body: bytes.NewBufferString("Hello world!"),
-			},
-			inRes: &Response{},
-		},
-		{
-			name: "Absent code",
-			inRec: &httptest.ResponseRecorder{
-				Code: http.StatusOK,
-				Body: bytes.NewBufferString("Hello world!"),
-			},
-			inRes: &Response{
-				Body: "Hello world!",
-			},
-		},
-		{
-			name: "Absent body",
-			inRec: &httptest.ResponseRecorder{
-				Code: http.StatusCreated,
-				Body: bytes.NewBufferString("Hello world!"),
-			},
-			inRes: &Response{
-				Code: http.StatusCreated,
-			},
-		},
-		{
-			name: "Code mismatch",
-			inRec: &httptest.ResponseRecorder{
-				Code: http.StatusInternalServerError,
-				Body: bytes.NewBufferString("Hello world!"),
-			},
-			inRes: &Response{
-				Code: http.StatusOK,
-				Body: "Hello world!",
-			},
-			expectError: true,
-		},
-		{
-			name: "Body mismatch",
-			inRec: &httptest.ResponseRecorder{
-				Code: http.StatusOK,
-				Body: bytes.NewBufferString("Hello world!"),
-			},
-			inRes: &Response{
-				Code: http.StatusOK,
-				Body: "Not hello world",
-			},
-			expectError: true,
+			name:       "With query",
+			url:        "/items?sort=name",
+			query:      url.Values{"id": {"1", "2"}, "q": {"a b"}},
+			expectBody: "sort=name&id=1&id=2&q=a+b",
 		},
 	}
 	for _, tc := range tt {
 		t.Run(tc.name, func(t *testing.T) {
-			assertResponse(&tc.m, tc.inRec, tc.inRes)
-			if tc.m.errored != tc.expectError {
-				t.Errorf("Got %t, expected %t", tc.m.errored, tc.expectError)
+			for _, rn := range []*Runner{New(), New(WithServer())} {
+				var m mock
+				rn.Run(&m, h, TestCase{
+					Request: Request{Method: http.MethodGet, URL: tc.url, Query: tc.query},
+					Response: Response{
+						Body:        tc.expectBody,
+						ExpectQuery: tc.query,
+					},
+				})
+				if m.errored {
+					t.Errorf("Got %q, expected no errors", m.errors)
+				}
 			}
 		})
 	}
+
+	t.Run("Mismatch", func(t *testing.T) {
+		var m mock
+		Run(&m, h, TestCase{
+			Request:  Request{Method: http.MethodGet, URL: "/items?id=1&id=3"},
+			Response: Response{ExpectQuery: url.Values{"id": {"1", "2"}}},
+		})
+		exp := []string{`Handler saw query parameter "id" ["1" "3"], expected ["1" "2"]`}
+		if !reflect.DeepEqual(m.errors, exp) {
+			t.Errorf("Got %q, expected %q", m.errors, exp)
+		}
+	})
 }
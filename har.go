@@ -0,0 +1,127 @@
+package handlertest
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"strings"
+)
+
+// har is the subset of the HTTP Archive format that is needed to replay
+// requests and compare responses.
+type har struct {
+	Log struct {
+		Entries []harEntry `json:"entries"`
+	} `json:"log"`
+}
+
+type harEntry struct {
+	Request struct {
+		Method   string      `json:"method"`
+		URL      string      `json:"url"`
+		Headers  []harHeader `json:"headers"`
+		PostData *struct {
+			Text string `json:"text"`
+		} `json:"postData"`
+	} `json:"request"`
+	Response struct {
+		Status  int         `json:"status"`
+		Headers []harHeader `json:"headers"`
+		Content struct {
+			Text     string `json:"text"`
+			Encoding string `json:"encoding"`
+		} `json:"content"`
+	} `json:"response"`
+}
+
+type harHeader struct {
+	Name  string `json:"name"`
+	Value string `json:"value"`
+}
+
+// RunFromHAR reads an HTTP Archive (HAR) from path, and replays every recorded
+// request against h. The recorded response is the expected baseline: its
+// status code, body and headers are asserted. Headers that vary between runs,
+// such as Date, can be excluded through ignoreHeaders. As a HAR holds
+// decoded bodies, responses recorded with a Content-Encoding are decoded
+// before they are compared (see Response.Decompress). Every entry runs as a
+// separate test, so divergence is reported per entry.
+func RunFromHAR(t tt, h http.Handler, path string, ignoreHeaders ...string) {
+	New().RunFromHAR(t, h, path, ignoreHeaders...)
+}
+
+// RunFromHAR is like the package-level RunFromHAR, but with the Runner's
+// options applied.
+func (r *Runner) RunFromHAR(t tt, h http.Handler, path string, ignoreHeaders ...string) {
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Fatalf("io/ioutil: ReadFile: %s", err)
+		return
+	}
+	tcs, err := harTestCases(b, ignoreHeaders)
+	if err != nil {
+		t.Fatalf("%s", err)
+		return
+	}
+	r.Run(t, h, tcs...)
+}
+
+// harTestCases converts the entries of the HAR in b to test cases.
+func harTestCases(b []byte, ignoreHeaders []string) ([]TestCase, error) {
+	var archive har
+	if err := json.Unmarshal(b, &archive); err != nil {
+		return nil, fmt.Errorf("encoding/json: Unmarshal: %s", err)
+	}
+
+	ignore := make(map[string]bool, len(ignoreHeaders))
+	for _, h := range ignoreHeaders {
+		ignore[http.CanonicalHeaderKey(h)] = true
+	}
+
+	tcs := make([]TestCase, 0, len(archive.Log.Entries))
+	for i, e := range archive.Log.Entries {
+		tc := TestCase{
+			Name: fmt.Sprintf("#%d %s %s", i, e.Request.Method, e.Request.URL),
+			Request: Request{
+				Method: e.Request.Method,
+				URL:    e.Request.URL,
+			},
+			Response: Response{
+				Code: e.Response.Status,
+				Body: e.Response.Content.Text,
+			},
+		}
+		if e.Request.PostData != nil {
+			tc.Request.Body = e.Request.PostData.Text
+		}
+		for _, h := range e.Request.Headers {
+			// Skip HTTP/2 pseudo-headers such as :authority.
+			if strings.HasPrefix(h.Name, ":") {
+				continue
+			}
+			tc.Request.Headers = append(tc.Request.Headers, h.Name+": "+h.Value)
+		}
+		for _, h := range e.Response.Headers {
+			// The recorded content is decoded, so the replayed response must
+			// be as well.
+			if http.CanonicalHeaderKey(h.Name) == "Content-Encoding" {
+				tc.Response.Decompress = true
+			}
+			if strings.HasPrefix(h.Name, ":") || ignore[http.CanonicalHeaderKey(h.Name)] {
+				continue
+			}
+			tc.Response.Headers = append(tc.Response.Headers, h.Name+": "+h.Value)
+		}
+		if e.Response.Content.Encoding == "base64" {
+			body, err := base64.StdEncoding.DecodeString(e.Response.Content.Text)
+			if err != nil {
+				return nil, fmt.Errorf("entry %d: encoding/base64: DecodeString: %s", i, err)
+			}
+			tc.Response.Body = string(body)
+		}
+		tcs = append(tcs, tc)
+	}
+	return tcs, nil
+}
@@ -0,0 +1,70 @@
+package handlertest
+
+import (
+	"compress/gzip"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"testing"
+)
+
+func TestRunFromHAR(t *testing.T) {
+	t.Run("Fatal on non-existing file", func(t *testing.T) {
+		var m mock
+		RunFromHAR(&m, emptyHandler, "clearly/non/existing/file")
+
+		if !m.fataled {
+			t.Errorf("Got false, expected true")
+		}
+	})
+
+	t.Run("Replays recorded entries", func(t *testing.T) {
+		h := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if ct := r.Header.Get("Content-Type"); ct != "text/plain" {
+				t.Errorf("Got %q, expected text/plain", ct)
+			}
+			if b, _ := ioutil.ReadAll(r.Body); string(b) != "Hello world" {
+				t.Errorf("Got %q, expected Hello world", b)
+			}
+			w.Header().Set("Content-Type", "text/plain")
+			w.WriteHeader(http.StatusCreated)
+			_, _ = w.Write([]byte("Bye world"))
+		})
+
+		var m mock
+		m.runFunc = func(name string, f func(t *testing.T)) bool {
+			if name != "#0 POST http://localhost:8080/foo?bar=baz" {
+				t.Errorf("Got %q, expected #0 POST http://localhost:8080/foo?bar=baz", name)
+			}
+			return t.Run(name, f)
+		}
+		// Date is not set by the handler, so it has to be ignored.
+		RunFromHAR(&m, h, "testdata/ok.har", "date")
+
+		if m.fataled {
+			t.Errorf("Got true, expected false")
+		}
+	})
+
+	t.Run("Replays compressed entries", func(t *testing.T) {
+		h := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("Content-Type", "text/plain")
+			if !strings.Contains(r.Header.Get("Accept-Encoding"), "gzip") {
+				_, _ = w.Write([]byte("Hello world"))
+				return
+			}
+			w.Header().Set("Content-Encoding", "gzip")
+			gw := gzip.NewWriter(w)
+			_, _ = gw.Write([]byte("Hello world"))
+			_ = gw.Close()
+		})
+
+		var m mock
+		m.runFunc = t.Run
+		RunFromHAR(&m, h, "testdata/gzip.har")
+
+		if m.errored || m.fataled {
+			t.Errorf("Got %q, expected no errors", m.errors)
+		}
+	})
+}
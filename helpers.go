@@ -0,0 +1,529 @@
+package handlertest
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// probeMethods are the methods RouterCases tries against every route.
+var probeMethods = []string{
+	http.MethodGet,
+	http.MethodHead,
+	http.MethodPost,
+	http.MethodPut,
+	http.MethodPatch,
+	http.MethodDelete,
+}
+
+// RouterCases generates test cases for the fallback behavior of a router that
+// serves routes, which maps paths to the methods they accept. For every path,
+// an unrouted sibling path, or /not-found for the root, is expected to
+// respond with 404 Not Found, and every other common method is expected to
+// respond with 405 Method Not Allowed and an Allow header. As most routers
+// implicitly accept HEAD for GET, HEAD is not probed on paths that accept
+// GET.
+func RouterCases(routes map[string][]string) []TestCase {
+	paths := make([]string, 0, len(routes))
+	for p := range routes {
+		paths = append(paths, p)
+	}
+	sort.Strings(paths)
+
+	var tcs []TestCase
+	for _, p := range paths {
+		unknown := strings.TrimSuffix(p, "/") + "-not-found"
+		if strings.TrimSuffix(p, "/") == "" {
+			unknown = "/not-found"
+		}
+		tcs = append(tcs, TestCase{
+			Name:     "GET " + unknown + " is not found",
+			Request:  Request{Method: http.MethodGet, URL: unknown},
+			Response: Response{Code: http.StatusNotFound},
+		})
+
+		allowed := make(map[string]bool)
+		for _, m := range routes[p] {
+			allowed[strings.ToUpper(m)] = true
+		}
+		for _, m := range probeMethods {
+			if allowed[m] || (m == http.MethodHead && allowed[http.MethodGet]) {
+				continue
+			}
+			tcs = append(tcs, TestCase{
+				Name:     m + " " + p + " is not allowed",
+				Request:  Request{Method: m, URL: p},
+				Response: Response{Code: http.StatusMethodNotAllowed},
+			})
+		}
+	}
+	return tcs
+}
+
+// EmptyBodyCase returns a test case that fires a request without a body, for
+// which the handler is expected to respond with expectCode. The request's
+// Body is http.NoBody.
+func EmptyBodyCase(url, method string, expectCode int) TestCase {
+	return TestCase{
+		Name:     method + " " + url + " without body",
+		Request:  Request{Method: method, URL: url},
+		Response: Response{Code: expectCode},
+	}
+}
+
+// PanicCase returns a test case for a request that makes the handler panic.
+// Recovery middleware is expected to turn the panic into a 500 Internal Server
+// Error with a JSON error envelope that has at least the keys in envelope. If
+// the handler does not recover, the test case fails.
+func PanicCase(method, url string, envelope ...string) TestCase {
+	if envelope == nil {
+		envelope = []string{}
+	}
+	return TestCase{
+		Name:    method + " " + url + " recovers from panic",
+		Request: Request{Method: method, URL: url},
+		Response: Response{
+			Code:          http.StatusInternalServerError,
+			ErrorEnvelope: envelope,
+		},
+	}
+}
+
+// OversizedHeaderCase returns a test case that sends req with n additional
+// headers, X-Oversized-1 through X-Oversized-n, each with a value of size
+// bytes, for which the handler is expected to respond with expectCode, such as
+// 431 Request Header Fields Too Large. This suits testing middleware that
+// limits the size of request headers. Note that a live server (see
+// WithServer) rejects headers beyond http.DefaultMaxHeaderBytes itself.
+func OversizedHeaderCase(req Request, n, size, expectCode int) TestCase {
+	value := strings.Repeat("x", size)
+	r := req
+	r.Headers = append([]string(nil), req.Headers...)
+	for i := 1; i <= n; i++ {
+		r.Headers = append(r.Headers, "X-Oversized-"+strconv.Itoa(i)+": "+value)
+	}
+	return TestCase{
+		Name:     fmt.Sprintf("%s %s with %d headers of %d bytes", req.Method, req.URL, n, size),
+		Request:  r,
+		Response: Response{Code: expectCode},
+	}
+}
+
+// RunRoundTrip runs tc against h, and then the test case next constructs from
+// the response body of tc, e.g. a request that feeds an encoded body back to
+// a decoding endpoint. This asserts encoders and decoders are symmetric. The
+// failures of each step are prefixed with that step, to show where the round
+// trip diverged. If the first step fails, the second is not run.
+func RunRoundTrip(t tt, h http.Handler, tc TestCase, next func(body string) TestCase) {
+	s := New().newSession(h)
+	defer s.close()
+
+	ft := &failureT{tt: prefixT{tt: t, prefix: "Round trip step 1: "}}
+	ex := s.run(ft, tc)
+	if ft.failed || ex == nil {
+		return
+	}
+	s.run(prefixT{tt: t, prefix: "Round trip step 2: "}, next(string(ex.body)))
+}
+
+// EncodingCases returns a test case for every encoding in encodings, which
+// sends req with that encoding as its Accept-Encoding header, and expects the
+// handler to respond with body. The response must be encoded in a way the
+// request accepts, and is decoded before it is compared (see
+// Response.Decompress), so only encodings it can decode, gzip and deflate,
+// can be passed besides identity. The encoding "none" sends no
+// Accept-Encoding header. If no encodings are passed, gzip, deflate, identity
+// and none are used.
+func EncodingCases(req Request, body string, encodings ...string) []TestCase {
+	if len(encodings) == 0 {
+		encodings = []string{"gzip", "deflate", "identity", "none"}
+	}
+
+	tcs := make([]TestCase, 0, len(encodings))
+	for _, enc := range encodings {
+		r := req
+		r.Headers = append([]string(nil), req.Headers...)
+		if enc != "none" {
+			r.Headers = append(r.Headers, "Accept-Encoding: "+enc)
+		}
+		tcs = append(tcs, TestCase{
+			Name:     "Accept-Encoding: " + enc,
+			Request:  r,
+			Response: Response{Body: body, Decompress: true},
+		})
+	}
+	return tcs
+}
+
+// LanguageCases returns a test case for every language in bodies, which maps
+// language tags to localized bodies. Each sends req with the language as its
+// Accept-Language header, and expects the handler to respond with its body
+// and the language as its Content-Language header. The test cases are named
+// after the language, and are sorted by it.
+func LanguageCases(req Request, bodies map[string]string) []TestCase {
+	langs := make([]string, 0, len(bodies))
+	for lang := range bodies {
+		langs = append(langs, lang)
+	}
+	sort.Strings(langs)
+
+	tcs := make([]TestCase, 0, len(langs))
+	for _, lang := range langs {
+		r := req
+		r.Headers = append(append([]string(nil), req.Headers...), "Accept-Language: "+lang)
+		tcs = append(tcs, TestCase{
+			Name:    "Accept-Language: " + lang,
+			Request: r,
+			Response: Response{
+				Body:    bodies[lang],
+				Headers: []string{"Content-Language: " + lang},
+			},
+		})
+	}
+	return tcs
+}
+
+// UnsupportedMediaTypeCases returns a test case for every media type in
+// types, which sends body to url with method, and that media type as its
+// Content-Type header. The handler is expected to reject each with 415
+// Unsupported Media Type. If no types are passed, text/plain, application/xml
+// and application/octet-stream are used.
+func UnsupportedMediaTypeCases(method, url, body string, types ...string) []TestCase {
+	if len(types) == 0 {
+		types = []string{"text/plain", "application/xml", "application/octet-stream"}
+	}
+
+	tcs := make([]TestCase, 0, len(types))
+	for _, ct := range types {
+		tcs = append(tcs, TestCase{
+			Name: method + " " + url + " with Content-Type: " + ct,
+			Request: Request{
+				Method:  method,
+				URL:     url,
+				Body:    body,
+				Headers: []string{"Content-Type: " + ct},
+			},
+			Response: Response{Code: http.StatusUnsupportedMediaType},
+		})
+	}
+	return tcs
+}
+
+// Bounds is an inclusive range of integers.
+type Bounds struct {
+	Min int
+	Max int
+}
+
+func (b Bounds) contains(i int) bool {
+	return i >= b.Min && i <= b.Max
+}
+
+// ExpectCodeDistribution maps status codes to the bounds of the number of
+// responses with that code. Codes that are not in the map are not expected to
+// occur at all.
+type ExpectCodeDistribution map[int]Bounds
+
+// AssertCodeDistribution fires req at h n times, and flags t as failed if the
+// number of responses with any status code is outside the bounds of dist. This
+// suits handlers that respond differently by chance, such as canary routing.
+func AssertCodeDistribution(t tt, h http.Handler, req Request, n int, dist ExpectCodeDistribution) {
+	observed := make(map[int]int)
+	for i := 0; i < n; i++ {
+		rec, err := record(h, &req)
+		if err != nil {
+			t.Errorf("Cannot fire request: %s", err)
+			return
+		}
+		observed[rec.Code]++
+	}
+
+	codes := make([]int, 0, len(observed)+len(dist))
+	for code := range observed {
+		codes = append(codes, code)
+	}
+	for code := range dist {
+		if _, ok := observed[code]; !ok {
+			codes = append(codes, code)
+		}
+	}
+	sort.Ints(codes)
+	for _, code := range codes {
+		b, count := dist[code], observed[code]
+		if !b.contains(count) {
+			t.Errorf("Got %d responses with code %d, expected between %d and %d (observed %v)", count, code, b.Min, b.Max, observed)
+		}
+	}
+}
+
+// AssertVaryAcceptEncoding fires req at h twice: once accepting gzip, and once
+// accepting only the identity encoding. If the responses differ in encoding or
+// body, both must list Accept-Encoding in their Vary header, as caches would
+// otherwise serve one variant to clients that asked for the other. A handler
+// that consistently ignores Accept-Encoding passes.
+func AssertVaryAcceptEncoding(t tt, h http.Handler, req Request) {
+	var recs []*httptest.ResponseRecorder
+	for _, ae := range []string{"gzip", "identity"} {
+		r := req
+		r.Headers = append(append([]string(nil), req.Headers...), "Accept-Encoding: "+ae)
+		rec, err := record(h, &r)
+		if err != nil {
+			t.Errorf("Cannot fire request with Accept-Encoding %q: %s", ae, err)
+			return
+		}
+		recs = append(recs, rec)
+	}
+
+	gz, id := recs[0].Result(), recs[1].Result()
+	if gz.Header.Get("Content-Encoding") == id.Header.Get("Content-Encoding") &&
+		recs[0].Body.String() == recs[1].Body.String() {
+		return
+	}
+	for i, res := range []*http.Response{gz, id} {
+		if !varies(res.Header, "Accept-Encoding") {
+			t.Errorf("Got Vary %q for Accept-Encoding %q, expected it to include Accept-Encoding as the response varies by it",
+				strings.Join(res.Header["Vary"], ", "), []string{"gzip", "identity"}[i])
+		}
+	}
+}
+
+// AssertStableETag fires req at h twice, and flags t as failed if either
+// response lacks an ETag header, or if the two differ: as long as the content
+// does not change, neither may its ETag. Every request in others is fired
+// once as well, and is expected to be for a different resource: its ETag must
+// differ from those of req and the other requests.
+func AssertStableETag(t tt, h http.Handler, req Request, others ...Request) {
+	reqs := append([]Request{req, req}, others...)
+	seen := make(map[string]string)
+	var first string
+	for i := range reqs {
+		rec, err := record(h, &reqs[i])
+		if err != nil {
+			t.Errorf("Cannot fire request for %s: %s", reqs[i].URL, err)
+			return
+		}
+		resource := reqs[i].Method + " " + reqs[i].URL
+		etag := rec.Result().Header.Get("ETag")
+		if etag == "" {
+			t.Errorf("Missing ETag for %s", resource)
+			continue
+		}
+
+		switch {
+		case i == 0:
+			first = etag
+			seen[etag] = resource
+		case i == 1:
+			if first != "" && etag != first {
+				t.Errorf("Got ETag %s for %s on the second request, expected %s as on the first", etag, resource, first)
+			}
+		default:
+			if other, ok := seen[etag]; ok {
+				t.Errorf("Got ETag %s for %s, colliding with %s", etag, resource, other)
+				continue
+			}
+			seen[etag] = resource
+		}
+	}
+}
+
+// varies reports whether the Vary header in hdr lists name.
+func varies(hdr http.Header, name string) bool {
+	for _, v := range hdr["Vary"] {
+		for _, field := range strings.Split(v, ",") {
+			field = strings.TrimSpace(field)
+			if field == "*" || strings.EqualFold(field, name) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// AssertIdempotent fires req at h twice, both times with key as its
+// Idempotency-Key header, and flags t as failed if the second response differs
+// in status code or body from the first. A handler that honors idempotency
+// keys replays its first response instead of processing the request again.
+func AssertIdempotent(t tt, h http.Handler, req Request, key string) {
+	r := req
+	r.Headers = append(append([]string(nil), req.Headers...), "Idempotency-Key: "+key)
+
+	var recs [2]*httptest.ResponseRecorder
+	for i := range recs {
+		rec, err := record(h, &r)
+		if err != nil {
+			t.Errorf("Cannot fire request %d with Idempotency-Key %q: %s", i+1, key, err)
+			return
+		}
+		recs[i] = rec
+	}
+
+	first, second := recs[0], recs[1]
+	if first.Code != second.Code {
+		t.Errorf("Got code %d for replayed Idempotency-Key %q, expected %d", second.Code, key, first.Code)
+	}
+	if b1, b2 := first.Body.String(), second.Body.String(); b1 != b2 {
+		t.Errorf("Got body for replayed Idempotency-Key %q that differs from the first: %s", key, diffText(b2, b1))
+	}
+}
+
+// AssertHeadMatchesGet fires req at h as both a GET and a HEAD request, and
+// flags t as failed if the HEAD response has a body, or if any header other
+// than those in ignore differs between both responses. Although net/http
+// discards what a handler writes in response to a HEAD request, a handler
+// that writes a body does needless work.
+func AssertHeadMatchesGet(t tt, h http.Handler, req Request, ignore ...string) {
+	var recs []*httptest.ResponseRecorder
+	for _, m := range []string{http.MethodGet, http.MethodHead} {
+		r := req
+		r.Method = m
+		rec, err := record(h, &r)
+		if err != nil {
+			t.Errorf("Cannot fire %s request: %s", m, err)
+			return
+		}
+		recs = append(recs, rec)
+	}
+
+	get, head := recs[0], recs[1]
+	if n := head.Body.Len(); n > 0 {
+		t.Errorf("Got HEAD response body of %d bytes, expected none", n)
+	}
+
+	skip := make(map[string]bool, len(ignore))
+	for _, k := range ignore {
+		skip[http.CanonicalHeaderKey(k)] = true
+	}
+	getHdr, headHdr := get.Result().Header, head.Result().Header
+	for _, k := range sortedHeaderKeys(getHdr, headHdr) {
+		if skip[k] {
+			continue
+		}
+		gv, gok := getHdr[k]
+		hv, hok := headHdr[k]
+		switch {
+		case !hok:
+			t.Errorf("Missing header %s %q on HEAD response, expected it as on GET", k, gv)
+		case !gok:
+			t.Errorf("Got header %s %q on HEAD response, expected it to be absent as on GET", k, hv)
+		case !reflect.DeepEqual(gv, hv):
+			t.Errorf("Got header %s %q on HEAD response, expected %q as on GET", k, hv, gv)
+		}
+	}
+}
+
+// AssertHeadContentLength fires req at h as both a GET and a HEAD request,
+// through a live server (see WithServer), and flags t as failed if the
+// Content-Length header of the HEAD response does not equal the length of the
+// GET response body. A handler that writes nothing in response to a HEAD
+// request often leaves out Content-Length, which net/http would otherwise
+// derive from the body.
+func AssertHeadContentLength(t tt, h http.Handler, req Request) {
+	s := New(WithServer()).newSession(h)
+	defer s.close()
+
+	var exs []*exchange
+	for _, m := range []string{http.MethodGet, http.MethodHead} {
+		r := req
+		r.Method = m
+		ex := s.run(prefixT{tt: t, prefix: m + ": "}, TestCase{Request: r})
+		if ex == nil {
+			return
+		}
+		exs = append(exs, ex)
+	}
+
+	n := len(exs[0].body)
+	cl := exs[1].rec.Result().Header.Get("Content-Length")
+	if cl == "" {
+		t.Errorf("Missing Content-Length on HEAD response, expected %d as the GET response body length", n)
+		return
+	}
+	if cl != strconv.Itoa(n) {
+		t.Errorf("Got Content-Length %s on HEAD response, expected %d as the GET response body length", cl, n)
+	}
+}
+
+// DefaultParamCases returns two test cases for the optional query parameter
+// param: one that sends req as is, without param, and expects the response
+// def, derived from the parameter's default; and one that adds param with
+// value to the query of req, and expects overridden. The test cases are named
+// after the variant they test.
+func DefaultParamCases(req Request, param, value string, def, overridden Response) []TestCase {
+	sep := "?"
+	if strings.Contains(req.URL, "?") {
+		sep = "&"
+	}
+	with := req
+	with.URL = req.URL + sep + url.QueryEscape(param) + "=" + url.QueryEscape(value)
+	return []TestCase{
+		{
+			Name:     req.Method + " " + req.URL + " without " + param,
+			Request:  req,
+			Response: def,
+		},
+		{
+			Name:     req.Method + " " + req.URL + " with " + param + "=" + value,
+			Request:  with,
+			Response: overridden,
+		},
+	}
+}
+
+// AssertLongPoll fires the request of tc at h, a handler that waits for an
+// event before it responds, such as a long-polling endpoint. Once h is
+// called, it is given a tenth of deadline to respond prematurely, after which
+// trigger is called to cause that event. t is flagged as failed if h
+// responded before, or does not respond within deadline after. The response
+// is then asserted as Run does. As trigger may be called before h starts
+// waiting, h must not miss events that occur in between. When
+// AssertLongPoll returns, the context of the request is canceled, and h is
+// expected to return once it is done.
+func AssertLongPoll(t tt, h http.Handler, tc TestCase, trigger func(), deadline time.Duration) {
+	started := make(chan struct{})
+	done := make(chan interface{}, 1)
+	req, rec := httpRequest(&tc.Request), httptest.NewRecorder()
+	ctx, cancel := context.WithCancel(req.Context())
+	defer cancel()
+	req = req.WithContext(ctx)
+	go func() {
+		done <- serve(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			close(started)
+			h.ServeHTTP(w, r)
+		}), rec, req)
+	}()
+
+	select {
+	case <-started:
+	case <-time.After(deadline):
+		t.Errorf("Handler was not called within %s", deadline)
+		return
+	}
+	select {
+	case <-done:
+		t.Errorf("Handler responded before the event was triggered")
+		return
+	case <-time.After(deadline / 10):
+	}
+
+	trigger()
+	start := time.Now()
+	select {
+	case p := <-done:
+		if p != nil {
+			t.Errorf("Handler panicked: %v", p)
+			return
+		}
+		res := expectedResponse(&tc)
+		New().assertResponse(t, &exchange{req: req, rec: rec, duration: time.Since(start)}, &res)
+	case <-time.After(deadline):
+		t.Errorf("Got no response within %s of triggering the event", deadline)
+	}
+}
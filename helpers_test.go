@@ -0,0 +1,736 @@
+package handlertest
+
+import (
+	"compress/gzip"
+	"encoding/base64"
+	"io/ioutil"
+	"net/http"
+	"reflect"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestRouterCases(t *testing.T) {
+	h := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/foo" {
+			http.NotFound(w, r)
+			return
+		}
+		if r.Method != http.MethodGet && r.Method != http.MethodHead && r.Method != http.MethodPost {
+			w.Header().Set("Allow", "GET, HEAD, POST")
+			w.WriteHeader(http.StatusMethodNotAllowed)
+		}
+	})
+
+	tcs := RouterCases(map[string][]string{"/foo": {"get", "post"}})
+	// One not found case, and PUT, PATCH and DELETE.
+	if len(tcs) != 4 {
+		t.Fatalf("Got %d, expected 4", len(tcs))
+	}
+	Run(t, h, tcs...)
+
+	t.Run("Root", func(t *testing.T) {
+		h := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if r.URL.Path != "/" {
+				http.NotFound(w, r)
+				return
+			}
+			if r.Method != http.MethodGet && r.Method != http.MethodHead {
+				w.Header().Set("Allow", "GET, HEAD")
+				w.WriteHeader(http.StatusMethodNotAllowed)
+			}
+		})
+
+		tcs := RouterCases(map[string][]string{"/": {"GET"}})
+		if tcs[0].Request.URL != "/not-found" {
+			t.Errorf("Got %q, expected %q", tcs[0].Request.URL, "/not-found")
+		}
+		Run(t, h, tcs...)
+	})
+}
+
+func TestEmptyBodyCase(t *testing.T) {
+	h := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Body == http.NoBody {
+			w.WriteHeader(http.StatusBadRequest)
+		}
+	})
+
+	var m mock
+	m.runFunc = t.Run
+	Run(&m, h, EmptyBodyCase("/foo", http.MethodPost, http.StatusBadRequest))
+}
+
+func TestPanicCase(t *testing.T) {
+	panicking := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		panic("oops")
+	})
+	recovering := func(body string) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			defer func() {
+				if recover() != nil {
+					w.Header().Set("Content-Type", "application/json")
+					w.WriteHeader(http.StatusInternalServerError)
+					_, _ = w.Write([]byte(body))
+				}
+			}()
+			panicking(w, r)
+		})
+	}
+	pc := PanicCase(http.MethodGet, "/foo", "error")
+	pc.Name = ""
+
+	tt := []struct {
+		name string
+
+		h http.Handler
+
+		expectError bool
+	}{
+		{
+			name: "Recovered to JSON envelope",
+			h:    recovering(`{"error": "internal"}`),
+		},
+		{
+			name:        "Recovered to invalid JSON",
+			h:           recovering(`internal error`),
+			expectError: true,
+		},
+		{
+			name:        "Recovered to JSON without envelope key",
+			h:           recovering(`{"message": "internal"}`),
+			expectError: true,
+		},
+		{
+			name:        "Not recovered",
+			h:           panicking,
+			expectError: true,
+		},
+	}
+	for _, tc := range tt {
+		t.Run(tc.name, func(t *testing.T) {
+			var m mock
+			Run(&m, tc.h, pc)
+			if m.errored != tc.expectError {
+				t.Errorf("Got %t (%q), expected %t", m.errored, m.errors, tc.expectError)
+			}
+		})
+	}
+}
+
+func TestOversizedHeaderCase(t *testing.T) {
+	const limit = 64 << 10
+	h := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var size int
+		for k, vs := range r.Header {
+			for _, v := range vs {
+				size += len(k) + len(v)
+			}
+		}
+		if size > limit {
+			w.WriteHeader(http.StatusRequestHeaderFieldsTooLarge)
+		}
+	})
+	req := Request{Method: http.MethodGet, URL: "/", Headers: []string{"Accept: */*"}}
+
+	tc := OversizedHeaderCase(req, 16, 8<<10, http.StatusRequestHeaderFieldsTooLarge)
+	if exp := "GET / with 16 headers of 8192 bytes"; tc.Name != exp {
+		t.Errorf("Got %q, expected %q", tc.Name, exp)
+	}
+	if len(tc.Request.Headers) != 17 || len(req.Headers) != 1 {
+		t.Errorf("Got %d and %d headers, expected 17 and 1", len(tc.Request.Headers), len(req.Headers))
+	}
+
+	var m mock
+	m.runFunc = t.Run
+	Run(&m, h, tc, OversizedHeaderCase(req, 1, 1<<10, http.StatusOK))
+}
+
+func TestRunRoundTrip(t *testing.T) {
+	h := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		b, _ := ioutil.ReadAll(r.Body)
+		switch r.URL.Path {
+		case "/encode":
+			_, _ = w.Write([]byte(base64.StdEncoding.EncodeToString(b)))
+		case "/decode":
+			dec, err := base64.StdEncoding.DecodeString(string(b))
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+			_, _ = w.Write(dec)
+		}
+	})
+	encode := TestCase{Request: Request{Method: http.MethodPost, URL: "/encode", Body: "Hello world!"}}
+
+	t.Run("Symmetric", func(t *testing.T) {
+		var m mock
+		RunRoundTrip(&m, h, encode, func(body string) TestCase {
+			return TestCase{
+				Request:  Request{Method: http.MethodPost, URL: "/decode", Body: body},
+				Response: Response{Body: "Hello world!"},
+			}
+		})
+
+		if m.errored {
+			t.Errorf("Got %q, expected no errors", m.errors)
+		}
+	})
+
+	t.Run("Diverged", func(t *testing.T) {
+		var m mock
+		RunRoundTrip(&m, h, encode, func(body string) TestCase {
+			return TestCase{
+				Request:  Request{Method: http.MethodPost, URL: "/decode", Body: body + "!"},
+				Response: Response{Body: "Hello world!"},
+			}
+		})
+
+		if len(m.errors) == 0 || !strings.HasPrefix(m.errors[0], "Round trip step 2: ") {
+			t.Errorf("Got %q, expected errors for step 2", m.errors)
+		}
+	})
+}
+
+func TestEncodingCases(t *testing.T) {
+	gzipping := func(always bool) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if !always && !strings.Contains(r.Header.Get("Accept-Encoding"), "gzip") {
+				_, _ = w.Write([]byte("Hello world!"))
+				return
+			}
+			w.Header().Set("Content-Encoding", "gzip")
+			gw := gzip.NewWriter(w)
+			_, _ = gw.Write([]byte("Hello world!"))
+			_ = gw.Close()
+		})
+	}
+
+	t.Run("Negotiated", func(t *testing.T) {
+		tcs := EncodingCases(Request{Method: http.MethodGet, URL: "/"}, "Hello world!")
+		var names []string
+		for _, tc := range tcs {
+			names = append(names, tc.Name)
+		}
+		expectNames := []string{"Accept-Encoding: gzip", "Accept-Encoding: deflate", "Accept-Encoding: identity", "Accept-Encoding: none"}
+		if !reflect.DeepEqual(names, expectNames) {
+			t.Errorf("Got %q, expected %q", names, expectNames)
+		}
+
+		var m mock
+		m.runFunc = t.Run
+		Run(&m, gzipping(false), tcs...)
+	})
+
+	t.Run("Not negotiated", func(t *testing.T) {
+		tcs := EncodingCases(Request{Method: http.MethodGet, URL: "/"}, "Hello world!", "gzip", "identity")
+		for i, expectError := range []bool{false, true} {
+			tc := tcs[i]
+			tc.Name = ""
+
+			var m mock
+			Run(&m, gzipping(true), tc)
+			if m.errored != expectError {
+				t.Errorf("%s: got %t (%q), expected %t", tcs[i].Name, m.errored, m.errors, expectError)
+			}
+		}
+	})
+}
+
+func TestLanguageCases(t *testing.T) {
+	greetings := map[string]string{"en": "Hello", "nl": "Hallo", "de": "Hallo"}
+	h := func(contentLanguage bool) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			lang := r.Header.Get("Accept-Language")
+			if contentLanguage {
+				w.Header().Set("Content-Language", lang)
+			}
+			_, _ = w.Write([]byte(greetings[lang]))
+		})
+	}
+	tcs := LanguageCases(Request{Method: http.MethodGet, URL: "/greeting"}, greetings)
+	for i, name := range []string{"Accept-Language: de", "Accept-Language: en", "Accept-Language: nl"} {
+		if tcs[i].Name != name {
+			t.Errorf("Got %q, expected %q", tcs[i].Name, name)
+		}
+	}
+
+	t.Run("Localized", func(t *testing.T) {
+		var m mock
+		m.runFunc = t.Run
+		Run(&m, h(true), tcs...)
+	})
+
+	t.Run("Without Content-Language", func(t *testing.T) {
+		for _, tc := range tcs {
+			var m mock
+			tc.Name = ""
+			Run(&m, h(false), tc)
+			if !m.errored {
+				t.Errorf("Got false, expected true")
+			}
+		}
+	})
+}
+
+func TestUnsupportedMediaTypeCases(t *testing.T) {
+	h := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Content-Type") != "application/json" {
+			w.WriteHeader(http.StatusUnsupportedMediaType)
+		}
+	})
+
+	tcs := UnsupportedMediaTypeCases(http.MethodPost, "/items", `{"name": "foo"}`)
+	if len(tcs) != 3 {
+		t.Fatalf("Got %d, expected 3", len(tcs))
+	}
+	var m mock
+	m.runFunc = t.Run
+	Run(&m, h, tcs...)
+
+	t.Run("Accepted", func(t *testing.T) {
+		var m mock
+		tc := UnsupportedMediaTypeCases(http.MethodPost, "/items", `{"name": "foo"}`, "application/json")[0]
+		tc.Name = ""
+		Run(&m, h, tc)
+		exp := []string{"Got response code 200, expected 415"}
+		if !reflect.DeepEqual(m.errors, exp) {
+			t.Errorf("Got %q, expected %q", m.errors, exp)
+		}
+	})
+}
+
+func TestAssertCodeDistribution(t *testing.T) {
+	var i int
+	h := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// Every fourth request is unavailable.
+		if i++; i%4 == 0 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+		}
+	})
+	req := Request{Method: http.MethodGet, URL: "/"}
+
+	tt := []struct {
+		name string
+
+		dist ExpectCodeDistribution
+
+		expectError bool
+	}{
+		{
+			name: "Within bounds",
+			dist: ExpectCodeDistribution{
+				http.StatusOK:                 {Min: 70, Max: 80},
+				http.StatusServiceUnavailable: {Min: 20, Max: 30},
+			},
+		},
+		{
+			name: "Outside bounds",
+			dist: ExpectCodeDistribution{
+				http.StatusOK:                 {Min: 90, Max: 100},
+				http.StatusServiceUnavailable: {Min: 0, Max: 10},
+			},
+			expectError: true,
+		},
+		{
+			name:        "Unexpected code",
+			dist:        ExpectCodeDistribution{http.StatusOK: {Min: 0, Max: 100}},
+			expectError: true,
+		},
+		{
+			name: "Expected code missing",
+			dist: ExpectCodeDistribution{
+				http.StatusOK:                 {Min: 0, Max: 100},
+				http.StatusServiceUnavailable: {Min: 0, Max: 100},
+				http.StatusTooManyRequests:    {Min: 1, Max: 100},
+			},
+			expectError: true,
+		},
+	}
+	for _, tc := range tt {
+		t.Run(tc.name, func(t *testing.T) {
+			var m mock
+			AssertCodeDistribution(&m, h, req, 100, tc.dist)
+			if m.errored != tc.expectError {
+				t.Errorf("Got %t (%q), expected %t", m.errored, m.errors, tc.expectError)
+			}
+		})
+	}
+}
+
+func TestAssertVaryAcceptEncoding(t *testing.T) {
+	h := func(vary bool, negotiate bool) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if vary {
+				w.Header().Add("Vary", "Origin, accept-encoding")
+			}
+			if negotiate && strings.Contains(r.Header.Get("Accept-Encoding"), "gzip") {
+				w.Header().Set("Content-Encoding", "gzip")
+				gw := gzip.NewWriter(w)
+				_, _ = gw.Write([]byte("Hello world!"))
+				_ = gw.Close()
+				return
+			}
+			_, _ = w.Write([]byte("Hello world!"))
+		})
+	}
+	req := Request{Method: http.MethodGet, URL: "/"}
+
+	tt := []struct {
+		name string
+
+		h http.Handler
+
+		expectError bool
+	}{
+		{
+			name: "Varies and declares Vary",
+			h:    h(true, true),
+		},
+		{
+			name: "Ignores encoding",
+			h:    h(false, false),
+		},
+		{
+			name:        "Varies without Vary",
+			h:           h(false, true),
+			expectError: true,
+		},
+	}
+	for _, tc := range tt {
+		t.Run(tc.name, func(t *testing.T) {
+			var m mock
+			AssertVaryAcceptEncoding(&m, tc.h, req)
+			if m.errored != tc.expectError {
+				t.Errorf("Got %t (%q), expected %t", m.errored, m.errors, tc.expectError)
+			}
+		})
+	}
+}
+
+func TestAssertIdempotent(t *testing.T) {
+	h := func(honorKey bool) http.Handler {
+		var n int
+		seen := make(map[string]string)
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			key := r.Header.Get("Idempotency-Key")
+			if body, ok := seen[key]; ok && honorKey {
+				w.WriteHeader(http.StatusCreated)
+				_, _ = w.Write([]byte(body))
+				return
+			}
+			n++
+			body := "order " + strconv.Itoa(n)
+			seen[key] = body
+			w.WriteHeader(http.StatusCreated)
+			_, _ = w.Write([]byte(body))
+		})
+	}
+	req := Request{Method: http.MethodPost, URL: "/orders", Body: `{"item": "foo"}`}
+
+	tt := []struct {
+		name string
+
+		h http.Handler
+
+		expectError bool
+	}{
+		{
+			name: "Replays response",
+			h:    h(true),
+		},
+		{
+			name:        "Processes twice",
+			h:           h(false),
+			expectError: true,
+		},
+	}
+	for _, tc := range tt {
+		t.Run(tc.name, func(t *testing.T) {
+			var m mock
+			AssertIdempotent(&m, tc.h, req, "abc")
+			if m.errored != tc.expectError {
+				t.Errorf("Got %t (%q), expected %t", m.errored, m.errors, tc.expectError)
+			}
+		})
+	}
+}
+
+func TestAssertHeadMatchesGet(t *testing.T) {
+	h := func(f func(w http.ResponseWriter, r *http.Request)) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("Content-Type", "text/plain")
+			w.Header().Set("X-Request-Id", r.Method)
+			f(w, r)
+		})
+	}
+	req := Request{URL: "/"}
+
+	tt := []struct {
+		name string
+
+		h http.Handler
+
+		expectErrors []string
+	}{
+		{
+			name: "Consistent",
+			h: h(func(w http.ResponseWriter, r *http.Request) {
+				if r.Method != http.MethodHead {
+					_, _ = w.Write([]byte("Hello world!"))
+				}
+			}),
+		},
+		{
+			name: "Writes body on HEAD",
+			h: h(func(w http.ResponseWriter, r *http.Request) {
+				_, _ = w.Write([]byte("Hello world!"))
+			}),
+			expectErrors: []string{"Got HEAD response body of 12 bytes, expected none"},
+		},
+		{
+			name: "Differing headers",
+			h: h(func(w http.ResponseWriter, r *http.Request) {
+				if r.Method == http.MethodHead {
+					w.Header().Del("Content-Type")
+					w.Header().Set("Cache-Control", "no-store")
+					w.Header().Set("X-Version", "2")
+					return
+				}
+				w.Header().Set("X-Version", "1")
+				_, _ = w.Write([]byte("Hello world!"))
+			}),
+			expectErrors: []string{
+				`Got header Cache-Control ["no-store"] on HEAD response, expected it to be absent as on GET`,
+				`Missing header Content-Type ["text/plain"] on HEAD response, expected it as on GET`,
+				`Got header X-Version ["2"] on HEAD response, expected ["1"] as on GET`,
+			},
+		},
+	}
+	for _, tc := range tt {
+		t.Run(tc.name, func(t *testing.T) {
+			var m mock
+			AssertHeadMatchesGet(&m, tc.h, req, "x-request-id")
+			if !reflect.DeepEqual(m.errors, tc.expectErrors) {
+				t.Errorf("Got %q, expected %q", m.errors, tc.expectErrors)
+			}
+		})
+	}
+}
+
+func TestAssertHeadContentLength(t *testing.T) {
+	req := Request{URL: "/"}
+
+	tt := []struct {
+		name string
+
+		h http.HandlerFunc
+
+		expectErrors []string
+	}{
+		{
+			name: "Writes body on HEAD",
+			h: func(w http.ResponseWriter, r *http.Request) {
+				_, _ = w.Write([]byte("Hello world!"))
+			},
+		},
+		{
+			name: "Sets Content-Length",
+			h: func(w http.ResponseWriter, r *http.Request) {
+				w.Header().Set("Content-Length", "12")
+				if r.Method != http.MethodHead {
+					_, _ = w.Write([]byte("Hello world!"))
+				}
+			},
+		},
+		{
+			name: "Missing Content-Length",
+			h: func(w http.ResponseWriter, r *http.Request) {
+				if r.Method != http.MethodHead {
+					_, _ = w.Write([]byte("Hello world!"))
+				}
+			},
+			expectErrors: []string{"Missing Content-Length on HEAD response, expected 12 as the GET response body length"},
+		},
+		{
+			name: "Wrong Content-Length",
+			h: func(w http.ResponseWriter, r *http.Request) {
+				if r.Method == http.MethodHead {
+					w.Header().Set("Content-Length", "5")
+					return
+				}
+				_, _ = w.Write([]byte("Hello world!"))
+			},
+			expectErrors: []string{"Got Content-Length 5 on HEAD response, expected 12 as the GET response body length"},
+		},
+	}
+	for _, tc := range tt {
+		t.Run(tc.name, func(t *testing.T) {
+			var m mock
+			AssertHeadContentLength(&m, tc.h, req)
+			if !reflect.DeepEqual(m.errors, tc.expectErrors) {
+				t.Errorf("Got %q, expected %q", m.errors, tc.expectErrors)
+			}
+		})
+	}
+}
+
+func TestDefaultParamCases(t *testing.T) {
+	h := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		limit := r.URL.Query().Get("limit")
+		if limit == "" {
+			limit = "10"
+		}
+		_, _ = w.Write([]byte(r.URL.Query().Get("sort") + " " + limit))
+	})
+
+	tcs := DefaultParamCases(Request{Method: http.MethodGet, URL: "/items?sort=name"}, "limit", "50",
+		Response{Body: "name 10"}, Response{Body: "name 50"})
+	names := []string{"GET /items?sort=name without limit", "GET /items?sort=name with limit=50"}
+	for i, tc := range tcs {
+		if tc.Name != names[i] {
+			t.Errorf("Got %q, expected %q", tc.Name, names[i])
+		}
+	}
+
+	var m mock
+	m.runFunc = t.Run
+	Run(&m, h, tcs...)
+}
+
+func TestAssertLongPoll(t *testing.T) {
+	tc := TestCase{
+		Request:  Request{Method: http.MethodGet, URL: "/events"},
+		Response: Response{Body: "event"},
+	}
+
+	t.Run("Responds after event", func(t *testing.T) {
+		events := make(chan string, 1)
+		h := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			_, _ = w.Write([]byte(<-events))
+		})
+
+		var m mock
+		AssertLongPoll(&m, h, tc, func() { events <- "event" }, time.Second)
+		if m.errored {
+			t.Errorf("Got %q, expected no errors", m.errors)
+		}
+	})
+
+	t.Run("Does not respond", func(t *testing.T) {
+		release := make(chan struct{})
+		defer close(release)
+		h := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			<-release
+		})
+
+		var m mock
+		AssertLongPoll(&m, h, tc, func() {}, 10*time.Millisecond)
+		exp := []string{"Got no response within 10ms of triggering the event"}
+		if !reflect.DeepEqual(m.errors, exp) {
+			t.Errorf("Got %q, expected %q", m.errors, exp)
+		}
+	})
+
+	t.Run("Responds immediately", func(t *testing.T) {
+		h := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			_, _ = w.Write([]byte("event"))
+		})
+
+		var m mock
+		AssertLongPoll(&m, h, tc, func() {}, time.Second)
+		exp := []string{"Handler responded before the event was triggered"}
+		if !reflect.DeepEqual(m.errors, exp) {
+			t.Errorf("Got %q, expected %q", m.errors, exp)
+		}
+	})
+
+	t.Run("Unblocked on timeout", func(t *testing.T) {
+		returned := make(chan struct{})
+		h := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			defer close(returned)
+			<-r.Context().Done()
+		})
+
+		var m mock
+		AssertLongPoll(&m, h, tc, func() {}, 10*time.Millisecond)
+		select {
+		case <-returned:
+		case <-time.After(time.Second):
+			t.Errorf("Got handler still running, expected it to return")
+		}
+	})
+
+	t.Run("Wrong response", func(t *testing.T) {
+		events := make(chan string, 1)
+		h := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			_, _ = w.Write([]byte(<-events))
+		})
+
+		var m mock
+		AssertLongPoll(&m, h, tc, func() { events <- "other" }, time.Second)
+		exp := []string{`Got response body "other", expected "event"`}
+		if !reflect.DeepEqual(m.errors, exp) {
+			t.Errorf("Got %q, expected %q", m.errors, exp)
+		}
+	})
+}
+
+func TestAssertStableETag(t *testing.T) {
+	h := func(etag func(r *http.Request) string) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if e := etag(r); e != "" {
+				w.Header().Set("ETag", e)
+			}
+		})
+	}
+	var n int
+	req := Request{Method: http.MethodGet, URL: "/items/1"}
+	other := Request{Method: http.MethodGet, URL: "/items/2"}
+
+	tt := []struct {
+		name string
+
+		h http.Handler
+
+		expectErrors []string
+	}{
+		{
+			name: "Stable and distinct",
+			h:    h(func(r *http.Request) string { return `"` + r.URL.Path + `"` }),
+		},
+		{
+			name: "Unstable",
+			h: h(func(r *http.Request) string {
+				n++
+				return `"` + strconv.Itoa(n) + `"`
+			}),
+			expectErrors: []string{`Got ETag "2" for GET /items/1 on the second request, expected "1" as on the first`},
+		},
+		{
+			name:         "Colliding",
+			h:            h(func(r *http.Request) string { return `"static"` }),
+			expectErrors: []string{`Got ETag "static" for GET /items/2, colliding with GET /items/1`},
+		},
+		{
+			name: "Missing",
+			h:    h(func(r *http.Request) string { return "" }),
+			expectErrors: []string{
+				"Missing ETag for GET /items/1",
+				"Missing ETag for GET /items/1",
+				"Missing ETag for GET /items/2",
+			},
+		},
+	}
+	for _, tc := range tt {
+		t.Run(tc.name, func(t *testing.T) {
+			var m mock
+			AssertStableETag(&m, tc.h, req, other)
+			if !reflect.DeepEqual(m.errors, tc.expectErrors) {
+				t.Errorf("Got %q, expected %q", m.errors, tc.expectErrors)
+			}
+		})
+	}
+}
@@ -0,0 +1,445 @@
+package handlertest
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"math"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// decodeJSON unmarshals b into a generic value, as encoding/json would for an
+// interface{}.
+func decodeJSON(b []byte) (interface{}, error) {
+	var v interface{}
+	if err := json.Unmarshal(b, &v); err != nil {
+		return nil, err
+	}
+	return v, nil
+}
+
+// lookupJSON returns the value at path within v. A path consists of keys
+// separated by dots and array indexes in brackets, e.g. "data.items[0].id". It
+// may be prefixed with "$". An empty path refers to v itself.
+func lookupJSON(v interface{}, path string) (interface{}, error) {
+	segments, err := parsePath(path)
+	if err != nil {
+		return nil, err
+	}
+	for i, seg := range segments {
+		switch seg := seg.(type) {
+		case string:
+			m, ok := v.(map[string]interface{})
+			if !ok {
+				return nil, fmt.Errorf("%s is not an object", formatPath(segments[:i]))
+			}
+			if v, ok = m[seg]; !ok {
+				return nil, fmt.Errorf("%s does not exist", formatPath(segments[:i+1]))
+			}
+		case int:
+			a, ok := v.([]interface{})
+			if !ok {
+				return nil, fmt.Errorf("%s is not an array", formatPath(segments[:i]))
+			}
+			if seg >= len(a) {
+				return nil, fmt.Errorf("%s does not exist", formatPath(segments[:i+1]))
+			}
+			v = a[seg]
+		}
+	}
+	return v, nil
+}
+
+// removeJSON removes the value at each of paths from v, if it exists. An
+// array element is set to null rather than removed, so the indexes of the
+// other elements are unchanged. See lookupJSON for the syntax of paths.
+func removeJSON(v interface{}, paths []string) error {
+	for _, path := range paths {
+		segments, err := parsePath(path)
+		if err != nil {
+			return err
+		}
+		if len(segments) == 0 {
+			continue
+		}
+		parent, err := lookupJSON(v, formatPath(segments[:len(segments)-1]))
+		if err != nil {
+			continue
+		}
+		switch last := segments[len(segments)-1].(type) {
+		case string:
+			if m, ok := parent.(map[string]interface{}); ok {
+				delete(m, last)
+			}
+		case int:
+			if a, ok := parent.([]interface{}); ok && last < len(a) {
+				a[last] = nil
+			}
+		}
+	}
+	return nil
+}
+
+// parsePath splits path into its segments: strings for object keys and ints
+// for array indexes.
+func parsePath(path string) ([]interface{}, error) {
+	path = strings.TrimPrefix(path, "$")
+	var segments []interface{}
+	for path != "" {
+		switch path[0] {
+		case '.':
+			path = path[1:]
+		case '[':
+			end := strings.IndexByte(path, ']')
+			if end == -1 {
+				return nil, fmt.Errorf("path has unterminated index: %q", path)
+			}
+			i, err := strconv.Atoi(path[1:end])
+			if err != nil || i < 0 {
+				return nil, fmt.Errorf("path has invalid index: %q", path[1:end])
+			}
+			segments = append(segments, i)
+			path = path[end+1:]
+		default:
+			end := strings.IndexAny(path, ".[")
+			if end == -1 {
+				end = len(path)
+			}
+			segments = append(segments, path[:end])
+			path = path[end:]
+		}
+	}
+	return segments, nil
+}
+
+// formatPath is the inverse of parsePath.
+func formatPath(segments []interface{}) string {
+	var sb strings.Builder
+	sb.WriteString("$")
+	for _, seg := range segments {
+		switch seg := seg.(type) {
+		case string:
+			sb.WriteString("." + seg)
+		case int:
+			sb.WriteString("[" + strconv.Itoa(seg) + "]")
+		}
+	}
+	return sb.String()
+}
+
+// lessJSON reports whether a sorts before b. Only numbers and strings can be
+// compared, and only with values of the same type.
+func lessJSON(a, b interface{}) (bool, error) {
+	switch a := a.(type) {
+	case float64:
+		if b, ok := b.(float64); ok {
+			return a < b, nil
+		}
+	case string:
+		if b, ok := b.(string); ok {
+			return a < b, nil
+		}
+	}
+	return false, fmt.Errorf("cannot compare %v (%T) with %v (%T)", a, a, b, b)
+}
+
+// normalizeJSON converts v, which may have been decoded from YAML or
+// constructed in Go, to the types encoding/json decodes into.
+func normalizeJSON(v interface{}) (interface{}, error) {
+	b, err := json.Marshal(stringKeys(v))
+	if err != nil {
+		return nil, err
+	}
+	return decodeJSON(b)
+}
+
+// stringKeys recursively converts the map[interface{}]interface{} values
+// produced by YAML to map[string]interface{}, which encoding/json supports.
+func stringKeys(v interface{}) interface{} {
+	switch v := v.(type) {
+	case map[interface{}]interface{}:
+		m := make(map[string]interface{}, len(v))
+		for k, e := range v {
+			m[fmt.Sprint(k)] = stringKeys(e)
+		}
+		return m
+	case map[string]interface{}:
+		m := make(map[string]interface{}, len(v))
+		for k, e := range v {
+			m[k] = stringKeys(e)
+		}
+		return m
+	case []interface{}:
+		a := make([]interface{}, len(v))
+		for i, e := range v {
+			a[i] = stringKeys(e)
+		}
+		return a
+	}
+	return v
+}
+
+// jsonDiffer compares decoded JSON values.
+type jsonDiffer struct {
+	// subset allows objects in the actual value to have keys that are not in
+	// the expected value.
+	subset bool
+	// emptyAsNull treats empty arrays and objects as null.
+	emptyAsNull bool
+	// epsilon is the largest difference at which numbers compare equal.
+	epsilon float64
+	// unordered compares arrays as sets: every expected element must equal
+	// a distinct element of the actual array, in any order.
+	unordered bool
+}
+
+// jsonDiffer returns the differ for comparing JSON bodies as configured by res.
+func (res *Response) jsonDiffer() jsonDiffer {
+	return jsonDiffer{
+		subset:      res.JSONSubset,
+		emptyAsNull: res.JSONEmptyAsNull,
+		epsilon:     res.JSONEpsilon,
+		unordered:   res.JSONUnorderedArrays,
+	}
+}
+
+// diff returns a description of every difference between exp and act, which
+// are both located at path.
+func (d *jsonDiffer) diff(exp, act interface{}, path []interface{}) []string {
+	if name, ok := matcherFor(exp); ok {
+		if err := match(name, act); err != nil {
+			return []string{fmt.Sprintf("%s: got %s, not matching %s: %s", formatPath(path), formatJSON(act), name, err)}
+		}
+		return nil
+	}
+	if d.emptyAsNull {
+		exp, act = emptyToNull(exp), emptyToNull(act)
+	}
+	switch exp := exp.(type) {
+	case map[string]interface{}:
+		m, ok := act.(map[string]interface{})
+		if !ok {
+			return []string{fmt.Sprintf("%s: got %s, expected an object", formatPath(path), formatJSON(act))}
+		}
+		var diffs []string
+		for _, k := range sortedKeys(exp) {
+			p := appendPath(path, k)
+			a, ok := m[k]
+			if !ok {
+				diffs = append(diffs, fmt.Sprintf("%s: missing, expected %s", formatPath(p), formatJSON(exp[k])))
+				continue
+			}
+			diffs = append(diffs, d.diff(exp[k], a, p)...)
+		}
+		if !d.subset {
+			for _, k := range sortedKeys(m) {
+				if _, ok := exp[k]; !ok {
+					diffs = append(diffs, fmt.Sprintf("%s: got %s, expected it to be absent", formatPath(appendPath(path, k)), formatJSON(m[k])))
+				}
+			}
+		}
+		return diffs
+	case []interface{}:
+		a, ok := act.([]interface{})
+		if !ok {
+			return []string{fmt.Sprintf("%s: got %s, expected an array", formatPath(path), formatJSON(act))}
+		}
+		if len(a) != len(exp) {
+			return []string{fmt.Sprintf("%s: got array of length %d, expected %d", formatPath(path), len(a), len(exp))}
+		}
+		if d.unordered {
+			return d.diffUnordered(exp, a, path)
+		}
+		var diffs []string
+		for i := range exp {
+			diffs = append(diffs, d.diff(exp[i], a[i], appendPath(path, i))...)
+		}
+		return diffs
+	}
+	if e, ok := exp.(float64); ok && d.epsilon > 0 {
+		if a, ok := act.(float64); ok && math.Abs(a-e) <= d.epsilon {
+			return nil
+		}
+	}
+	if !reflect.DeepEqual(exp, act) {
+		return []string{fmt.Sprintf("%s: got %s, expected %s", formatPath(path), formatJSON(act), formatJSON(exp))}
+	}
+	return nil
+}
+
+// diffUnordered compares the arrays exp and act, which are of the same length,
+// regardless of the order of their elements. As an expected element may match
+// several actual ones, e.g. when comparing subsets, elements are paired by a
+// maximum bipartite matching rather than greedily.
+func (d *jsonDiffer) diffUnordered(exp, act []interface{}, path []interface{}) []string {
+	matches := make([][]bool, len(exp))
+	for i := range exp {
+		matches[i] = make([]bool, len(act))
+		for j := range act {
+			matches[i][j] = len(d.diff(exp[i], act[j], nil)) == 0
+		}
+	}
+
+	// pairedWith maps every actual element to the expected element it is
+	// paired with, or -1.
+	pairedWith := make([]int, len(act))
+	for j := range pairedWith {
+		pairedWith[j] = -1
+	}
+	// pair tries to pair expected element i, by finding an augmenting path
+	// that re-pairs the expected elements already paired.
+	var pair func(i int, visited []bool) bool
+	pair = func(i int, visited []bool) bool {
+		for j := range act {
+			if !matches[i][j] || visited[j] {
+				continue
+			}
+			visited[j] = true
+			if pairedWith[j] < 0 || pair(pairedWith[j], visited) {
+				pairedWith[j] = i
+				return true
+			}
+		}
+		return false
+	}
+
+	var diffs []string
+	for i := range exp {
+		if !pair(i, make([]bool, len(act))) {
+			diffs = append(diffs, fmt.Sprintf("%s: got no element matching %s", formatPath(path), formatJSON(exp[i])))
+		}
+	}
+	return diffs
+}
+
+// emptyToNull returns nil if v is an empty array or object, and v otherwise.
+func emptyToNull(v interface{}) interface{} {
+	switch vv := v.(type) {
+	case []interface{}:
+		if len(vv) == 0 {
+			return nil
+		}
+	case map[string]interface{}:
+		if len(vv) == 0 {
+			return nil
+		}
+	}
+	return v
+}
+
+// appendPath returns a copy of path with seg appended, so that sibling paths
+// never share a backing array.
+func appendPath(path []interface{}, seg interface{}) []interface{} {
+	p := make([]interface{}, len(path), len(path)+1)
+	copy(p, path)
+	return append(p, seg)
+}
+
+func sortedKeys(m map[string]interface{}) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// formatJSON formats v for use in failure messages.
+func formatJSON(v interface{}) string {
+	b, err := json.Marshal(v)
+	if err != nil {
+		return fmt.Sprint(v)
+	}
+	return string(b)
+}
+
+// objectKeys returns the keys of the JSON object at path within the raw JSON
+// b, in the order these appear in b. See lookupJSON for the syntax of path.
+func objectKeys(b []byte, path string) ([]string, error) {
+	segments, err := parsePath(path)
+	if err != nil {
+		return nil, err
+	}
+	dec := json.NewDecoder(bytes.NewReader(b))
+	for i, seg := range segments {
+		tok, err := dec.Token()
+		if err != nil {
+			return nil, err
+		}
+		found := false
+		switch seg := seg.(type) {
+		case string:
+			if tok != json.Delim('{') {
+				return nil, fmt.Errorf("%s is not an object", formatPath(segments[:i]))
+			}
+			for !found && dec.More() {
+				key, err := dec.Token()
+				if err != nil {
+					return nil, err
+				}
+				if found = key == seg; !found {
+					if err := skipJSONValue(dec); err != nil {
+						return nil, err
+					}
+				}
+			}
+		case int:
+			if tok != json.Delim('[') {
+				return nil, fmt.Errorf("%s is not an array", formatPath(segments[:i]))
+			}
+			for j := 0; !found && dec.More(); j++ {
+				if found = j == seg; !found {
+					if err := skipJSONValue(dec); err != nil {
+						return nil, err
+					}
+				}
+			}
+		}
+		if !found {
+			return nil, fmt.Errorf("%s does not exist", formatPath(segments[:i+1]))
+		}
+	}
+
+	tok, err := dec.Token()
+	if err != nil {
+		return nil, err
+	}
+	if tok != json.Delim('{') {
+		return nil, fmt.Errorf("%s is not an object", formatPath(segments))
+	}
+	var keys []string
+	for dec.More() {
+		key, err := dec.Token()
+		if err != nil {
+			return nil, err
+		}
+		keys = append(keys, key.(string))
+		if err := skipJSONValue(dec); err != nil {
+			return nil, err
+		}
+	}
+	return keys, nil
+}
+
+// skipJSONValue reads the next value from dec, and discards it.
+func skipJSONValue(dec *json.Decoder) error {
+	var depth int
+	for {
+		tok, err := dec.Token()
+		if err != nil {
+			return err
+		}
+		switch tok {
+		case json.Delim('{'), json.Delim('['):
+			depth++
+		case json.Delim('}'), json.Delim(']'):
+			depth--
+		}
+		if depth == 0 {
+			return nil
+		}
+	}
+}
@@ -0,0 +1,311 @@
+package handlertest
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestLookupJSON(t *testing.T) {
+	v, err := decodeJSON([]byte(`{"data": {"items": [{"id": 1}, {"id": 2}]}}`))
+	if err != nil {
+		t.Fatalf("decodeJSON: %s", err)
+	}
+
+	tt := []struct {
+		name string
+
+		path string
+
+		expect      interface{}
+		expectError bool
+	}{
+		{
+			name:   "Root",
+			path:   "",
+			expect: v,
+		},
+		{
+			name:   "Nested key and index",
+			path:   "data.items[1].id",
+			expect: float64(2),
+		},
+		{
+			name:   "With dollar prefix",
+			path:   "$.data.items[0].id",
+			expect: float64(1),
+		},
+		{
+			name:        "Missing key",
+			path:        "data.foo",
+			expectError: true,
+		},
+		{
+			name:        "Index out of range",
+			path:        "data.items[2]",
+			expectError: true,
+		},
+		{
+			name:        "Index on object",
+			path:        "data[0]",
+			expectError: true,
+		},
+		{
+			name:        "Unterminated index",
+			path:        "data.items[0",
+			expectError: true,
+		},
+	}
+	for _, tc := range tt {
+		t.Run(tc.name, func(t *testing.T) {
+			got, err := lookupJSON(v, tc.path)
+			if (err != nil) != tc.expectError {
+				t.Fatalf("Got error %v, expected error: %t", err, tc.expectError)
+			}
+			if !reflect.DeepEqual(got, tc.expect) {
+				t.Errorf("Got %v, expected %v", got, tc.expect)
+			}
+		})
+	}
+}
+
+func TestRemoveJSON(t *testing.T) {
+	tt := []struct {
+		name string
+
+		paths []string
+
+		expect      string
+		expectError bool
+	}{
+		{
+			name:   "Top-level and nested keys",
+			paths:  []string{"created_at", "$.meta.request_id"},
+			expect: `{"id": 1, "meta": {}, "items": [{"id": 2, "at": 3}, {"id": 4}]}`,
+		},
+		{
+			name:   "Nested in array",
+			paths:  []string{"items[0].at"},
+			expect: `{"id": 1, "created_at": "now", "meta": {"request_id": "abc"}, "items": [{"id": 2}, {"id": 4}]}`,
+		},
+		{
+			name:   "Array element",
+			paths:  []string{"items[1]"},
+			expect: `{"id": 1, "created_at": "now", "meta": {"request_id": "abc"}, "items": [{"id": 2, "at": 3}, null]}`,
+		},
+		{
+			name:   "Missing",
+			paths:  []string{"updated_at", "meta.trace.id", "items[5]"},
+			expect: `{"id": 1, "created_at": "now", "meta": {"request_id": "abc"}, "items": [{"id": 2, "at": 3}, {"id": 4}]}`,
+		},
+		{
+			name:        "Invalid path",
+			paths:       []string{"items[x]"},
+			expectError: true,
+		},
+	}
+	for _, tc := range tt {
+		t.Run(tc.name, func(t *testing.T) {
+			v, _ := decodeJSON([]byte(`{"id": 1, "created_at": "now", "meta": {"request_id": "abc"}, "items": [{"id": 2, "at": 3}, {"id": 4}]}`))
+			err := removeJSON(v, tc.paths)
+			if (err != nil) != tc.expectError {
+				t.Fatalf("Got error %v, expected error: %t", err, tc.expectError)
+			}
+			if tc.expectError {
+				return
+			}
+			exp, _ := decodeJSON([]byte(tc.expect))
+			if !reflect.DeepEqual(v, exp) {
+				t.Errorf("Got %v, expected %v", v, exp)
+			}
+		})
+	}
+}
+
+func TestObjectKeys(t *testing.T) {
+	b := []byte(`{"z": 1, "data": {"items": [{"b": [1, {"x": 2}], "a": 1}, {"d": null, "c": {"y": 3}}]}, "a": true}`)
+
+	tt := []struct {
+		name string
+
+		path string
+
+		expect      []string
+		expectError bool
+	}{
+		{
+			name:   "Root",
+			path:   "",
+			expect: []string{"z", "data", "a"},
+		},
+		{
+			name:   "Nested",
+			path:   "$.data.items[1]",
+			expect: []string{"d", "c"},
+		},
+		{
+			name:   "Skipping nested values",
+			path:   "data.items[1].c",
+			expect: []string{"y"},
+		},
+		{
+			name:        "Not an object",
+			path:        "data.items",
+			expectError: true,
+		},
+		{
+			name:        "Missing key",
+			path:        "data.foo",
+			expectError: true,
+		},
+		{
+			name:        "Index out of range",
+			path:        "data.items[2]",
+			expectError: true,
+		},
+	}
+	for _, tc := range tt {
+		t.Run(tc.name, func(t *testing.T) {
+			got, err := objectKeys(b, tc.path)
+			if (err != nil) != tc.expectError {
+				t.Fatalf("Got error %v, expected error: %t", err, tc.expectError)
+			}
+			if !reflect.DeepEqual(got, tc.expect) {
+				t.Errorf("Got %q, expected %q", got, tc.expect)
+			}
+		})
+	}
+}
+
+func TestJSONDiffer(t *testing.T) {
+	tt := []struct {
+		name string
+
+		exp         string
+		act         string
+		subset      bool
+		emptyAsNull bool
+		epsilon     float64
+		unordered   bool
+
+		expect []string
+	}{
+		{
+			name: "Equal",
+			exp:  `{"a": [1, {"b": true}], "c": null}`,
+			act:  `{"c": null, "a": [1, {"b": true}]}`,
+		},
+		{
+			name:   "Value mismatch",
+			exp:    `{"a": [1, {"b": true}]}`,
+			act:    `{"a": [1, {"b": false}]}`,
+			expect: []string{"$.a[1].b: got false, expected true"},
+		},
+		{
+			name:   "Missing and unexpected keys",
+			exp:    `{"a": 1}`,
+			act:    `{"b": 1}`,
+			expect: []string{"$.a: missing, expected 1", "$.b: got 1, expected it to be absent"},
+		},
+		{
+			name:   "Subset",
+			exp:    `{"a": 1}`,
+			act:    `{"a": 1, "b": 2}`,
+			subset: true,
+		},
+		{
+			name:        "Empty as null",
+			exp:         `{"a": [], "b": null, "c": {}, "d": [[], {"e": null}]}`,
+			act:         `{"a": null, "b": {}, "c": [], "d": [null, {"e": []}]}`,
+			emptyAsNull: true,
+		},
+		{
+			name:        "Empty as null with residual differences",
+			exp:         `{"a": [], "b": null}`,
+			act:         `{"a": [1], "b": 0}`,
+			emptyAsNull: true,
+			expect:      []string{"$.a: got [1], expected null", "$.b: got 0, expected null"},
+		},
+		{
+			name:   "Empty not as null",
+			exp:    `{"a": []}`,
+			act:    `{"a": null}`,
+			expect: []string{"$.a: got null, expected an array"},
+		},
+		{
+			name:    "Within epsilon",
+			exp:     `{"avg": 0.3, "n": [1, 2]}`,
+			act:     `{"avg": 0.30000000000000004, "n": [1.0000001, 2]}`,
+			epsilon: 1e-6,
+		},
+		{
+			name:    "Beyond epsilon",
+			exp:     `{"avg": 0.3}`,
+			act:     `{"avg": 0.31}`,
+			epsilon: 1e-6,
+			expect:  []string{"$.avg: got 0.31, expected 0.3"},
+		},
+		{
+			name:      "Unordered arrays",
+			exp:       `{"ids": [3, 1, 2], "users": [{"id": 2}, {"id": 1}]}`,
+			act:       `{"ids": [1, 2, 3], "users": [{"id": 1}, {"id": 2}]}`,
+			unordered: true,
+		},
+		{
+			name:      "Unordered arrays with duplicates",
+			exp:       `[1, 1, 2]`,
+			act:       `[1, 2, 2]`,
+			unordered: true,
+			expect:    []string{"$: got no element matching 1"},
+		},
+		{
+			name:      "Unordered subsets",
+			exp:       `[{"a": 1}, {"a": 1, "b": 2}]`,
+			act:       `[{"a": 1, "b": 2}, {"a": 1}]`,
+			subset:    true,
+			unordered: true,
+		},
+		{
+			name:      "Unordered within epsilon",
+			exp:       `[1.0, 1.1]`,
+			act:       `[1.05, 1.0]`,
+			epsilon:   0.06,
+			unordered: true,
+		},
+		{
+			name:      "Unordered matchers",
+			exp:       `["{match:any}", "123e4567-e89b-12d3-a456-426614174000"]`,
+			act:       `["123e4567-e89b-12d3-a456-426614174000", "other"]`,
+			unordered: true,
+		},
+		{
+			name:   "Ordered arrays",
+			exp:    `[1, 2]`,
+			act:    `[2, 1]`,
+			expect: []string{"$[0]: got 2, expected 1", "$[1]: got 1, expected 2"},
+		},
+		{
+			name:   "Type mismatch",
+			exp:    `[1]`,
+			act:    `{"a": 1}`,
+			expect: []string{`$: got {"a":1}, expected an array`},
+		},
+	}
+	for _, tc := range tt {
+		t.Run(tc.name, func(t *testing.T) {
+			exp, err := decodeJSON([]byte(tc.exp))
+			if err != nil {
+				t.Fatalf("decodeJSON: %s", err)
+			}
+			act, err := decodeJSON([]byte(tc.act))
+			if err != nil {
+				t.Fatalf("decodeJSON: %s", err)
+			}
+
+			d := jsonDiffer{subset: tc.subset, emptyAsNull: tc.emptyAsNull, epsilon: tc.epsilon, unordered: tc.unordered}
+			got := d.diff(exp, act, nil)
+			if !reflect.DeepEqual(got, tc.expect) {
+				t.Errorf("Got %q, expected %q", got, tc.expect)
+			}
+		})
+	}
+}
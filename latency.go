@@ -0,0 +1,73 @@
+package handlertest
+
+import (
+	"math"
+	"sort"
+	"time"
+)
+
+// Latency describes the expected response times of a test case, which is run
+// repeatedly to measure these.
+type Latency struct {
+	// Runs is the number of times the request is fired, including the
+	// initial run. It defaults to 20.
+	Runs int
+	// Percentile is the percentile of the response times to assert, e.g. 95
+	// for p95. It defaults to 50, the median.
+	Percentile float64
+	// Max is the maximum response time at the percentile.
+	Max time.Duration
+}
+
+const defaultLatencyRuns = 20
+
+func assertMaxDuration(t tt, ex *exchange, res *Response) {
+	if res.MaxDuration > 0 && ex.duration > res.MaxDuration {
+		t.Errorf("Got response after %s, expected at most %s", ex.duration, res.MaxDuration)
+	}
+}
+
+// assertLatency fires the request of tc until it ran as often as its
+// ExpectLatency prescribes, first being the duration of the initial run, and
+// flags t as failed if the percentile of the response times exceeds the
+// maximum. Failures of the repeated runs are not reported again.
+func (s *session) assertLatency(t tt, tc TestCase, first time.Duration) {
+	l := tc.ExpectLatency
+	runs, pct := l.Runs, l.Percentile
+	if runs <= 0 {
+		runs = defaultLatencyRuns
+	}
+	if pct <= 0 {
+		pct = 50
+	}
+
+	durations := []time.Duration{first}
+	for len(durations) < runs {
+		ex := s.run(discardT{t}, tc)
+		if ex == nil {
+			t.Errorf("Cannot measure latency: run %d of %d failed", len(durations)+1, runs)
+			return
+		}
+		durations = append(durations, ex.duration)
+	}
+
+	if d := percentile(durations, pct); d > l.Max {
+		t.Errorf("Got p%g latency of %s over %d runs, expected at most %s", pct, d, runs, l.Max)
+	}
+}
+
+// percentile returns the p-th percentile of durations, using the nearest-rank
+// method. It sorts durations in place.
+func percentile(durations []time.Duration, p float64) time.Duration {
+	sort.Slice(durations, func(i, j int) bool {
+		return durations[i] < durations[j]
+	})
+	rank := int(math.Ceil(p / 100 * float64(len(durations))))
+	if rank < 1 {
+		rank = 1
+	}
+	if rank > len(durations) {
+		rank = len(durations)
+	}
+	return durations[rank-1]
+}
@@ -0,0 +1,104 @@
+package handlertest
+
+import (
+	"net/http"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"gopkg.in/yaml.v2"
+)
+
+func TestPercentile(t *testing.T) {
+	ds := []time.Duration{5, 1, 4, 2, 3, 10, 9, 8, 7, 6}
+
+	tt := []struct {
+		p      float64
+		expect time.Duration
+	}{
+		{0, 1},
+		{50, 5},
+		{90, 9},
+		{95, 10},
+		{100, 10},
+	}
+	for _, tc := range tt {
+		if got := percentile(append([]time.Duration(nil), ds...), tc.p); got != tc.expect {
+			t.Errorf("p%g: Got %d, expected %d", tc.p, got, tc.expect)
+		}
+	}
+}
+
+func TestExpectLatency(t *testing.T) {
+	var calls int
+	h := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls++
+		time.Sleep(5 * time.Millisecond)
+	})
+	tc := TestCase{Request: Request{Method: http.MethodGet, URL: "/"}}
+
+	t.Run("Within budget", func(t *testing.T) {
+		calls = 0
+		tc.ExpectLatency = &Latency{Runs: 3, Percentile: 95, Max: time.Second}
+
+		var m mock
+		Run(&m, h, tc)
+		if m.errored {
+			t.Errorf("Got %q, expected no errors", m.errors)
+		}
+		if calls != 3 {
+			t.Errorf("Got %d, expected 3", calls)
+		}
+	})
+
+	t.Run("Over budget", func(t *testing.T) {
+		tc.ExpectLatency = &Latency{Runs: 3, Percentile: 95, Max: time.Millisecond}
+
+		var m mock
+		Run(&m, h, tc)
+		if len(m.errors) != 1 || !strings.HasPrefix(m.errors[0], "Got p95 latency of ") {
+			t.Errorf("Got %q, expected p95 latency error", m.errors)
+		}
+	})
+}
+
+func TestAssertMaxDuration(t *testing.T) {
+	tt := []struct {
+		name string
+
+		duration time.Duration
+		max      time.Duration
+
+		expectErrors []string
+	}{
+		{name: "Unset", duration: time.Second},
+		{name: "Within budget", duration: 40 * time.Millisecond, max: 50 * time.Millisecond},
+		{name: "At budget", duration: 50 * time.Millisecond, max: 50 * time.Millisecond},
+		{
+			name:         "Over budget",
+			duration:     75 * time.Millisecond,
+			max:          50 * time.Millisecond,
+			expectErrors: []string{"Got response after 75ms, expected at most 50ms"},
+		},
+	}
+	for _, tc := range tt {
+		t.Run(tc.name, func(t *testing.T) {
+			var m mock
+			assertMaxDuration(&m, &exchange{duration: tc.duration}, &Response{MaxDuration: tc.max})
+			if !reflect.DeepEqual(m.errors, tc.expectErrors) {
+				t.Errorf("Got %q, expected %q", m.errors, tc.expectErrors)
+			}
+		})
+	}
+
+	t.Run("From YAML", func(t *testing.T) {
+		var res Response
+		if err := yaml.Unmarshal([]byte("maxduration: 50ms"), &res); err != nil {
+			t.Fatalf("Got %s, expected nil", err)
+		}
+		if res.MaxDuration != 50*time.Millisecond {
+			t.Errorf("Got %s, expected 50ms", res.MaxDuration)
+		}
+	})
+}
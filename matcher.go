@@ -0,0 +1,102 @@
+package handlertest
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Matcher matches a value within a JSON body, for values that cannot be
+// pinned exactly, such as generated identifiers. The value is decoded as
+// encoding/json does: a string, float64, bool, nil, []interface{} or
+// map[string]interface{}.
+type Matcher interface {
+	// Match returns an error describing why v does not match, or nil if it
+	// does.
+	Match(v interface{}) error
+}
+
+// MatcherFunc adapts a function to a Matcher.
+type MatcherFunc func(v interface{}) error
+
+// Match implements Matcher.
+func (f MatcherFunc) Match(v interface{}) error {
+	return f(v)
+}
+
+var (
+	matchersMu sync.RWMutex
+	matchers   = map[string]Matcher{
+		"any":       MatcherFunc(func(interface{}) error { return nil }),
+		"uuid":      MatcherFunc(matchUUID),
+		"timestamp": MatcherFunc(matchTimestamp),
+	}
+)
+
+// RegisterMatcher registers m under name, so that expected JSON values can
+// refer to it as Match(name), or "{match:name}" in YAML. The matchers any,
+// uuid and timestamp (RFC 3339) are registered by default. Registering a
+// matcher under an existing name replaces it.
+func RegisterMatcher(name string, m Matcher) {
+	matchersMu.Lock()
+	defer matchersMu.Unlock()
+	matchers[name] = m
+}
+
+// Match returns a placeholder for an expected JSON value, e.g. in BodyJSON,
+// BodyValue or JSONPaths, that the actual value matches if the matcher
+// registered under name accepts it. See RegisterMatcher.
+func Match(name string) string {
+	return "{match:" + name + "}"
+}
+
+// matcherRef matches the placeholder returned by Match.
+var matcherRef = regexp.MustCompile(`^\{match:([^{}]+)\}$`)
+
+// matcherFor returns the name of the matcher v refers to, if it is a
+// placeholder as returned by Match.
+func matcherFor(v interface{}) (string, bool) {
+	s, ok := v.(string)
+	if !ok || !strings.HasPrefix(s, "{match:") {
+		return "", false
+	}
+	m := matcherRef.FindStringSubmatch(s)
+	if m == nil {
+		return "", false
+	}
+	return m[1], true
+}
+
+// match matches v with the matcher registered under name.
+func match(name string, v interface{}) error {
+	matchersMu.RLock()
+	m, ok := matchers[name]
+	matchersMu.RUnlock()
+	if !ok {
+		return fmt.Errorf("no matcher registered as %q", name)
+	}
+	return m.Match(v)
+}
+
+var uuidPattern = regexp.MustCompile(`^[0-9a-fA-F]{8}-[0-9a-fA-F]{4}-[0-9a-fA-F]{4}-[0-9a-fA-F]{4}-[0-9a-fA-F]{12}$`)
+
+func matchUUID(v interface{}) error {
+	if s, ok := v.(string); !ok || !uuidPattern.MatchString(s) {
+		return errors.New("not a UUID")
+	}
+	return nil
+}
+
+func matchTimestamp(v interface{}) error {
+	s, ok := v.(string)
+	if !ok {
+		return errors.New("not an RFC 3339 timestamp")
+	}
+	if _, err := time.Parse(time.RFC3339Nano, s); err != nil {
+		return errors.New("not an RFC 3339 timestamp")
+	}
+	return nil
+}
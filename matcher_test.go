@@ -0,0 +1,82 @@
+package handlertest
+
+import (
+	"errors"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+
+	"gopkg.in/yaml.v2"
+)
+
+func TestMatchers(t *testing.T) {
+	RegisterMatcher("even", MatcherFunc(func(v interface{}) error {
+		if f, ok := v.(float64); !ok || int(f)%2 != 0 {
+			return errors.New("not an even number")
+		}
+		return nil
+	}))
+	body := `{"id": "123e4567-e89b-12d3-a456-426614174000", "created": "2020-02-29T12:00:00.5Z", "count": 4, "meta": {"trace": [1]}}`
+
+	tt := []struct {
+		name string
+
+		res Response
+
+		expectErrors []string
+	}{
+		{
+			name: "Matching from Go",
+			res: Response{
+				BodyValue: map[string]interface{}{
+					"id":      Match("uuid"),
+					"created": Match("timestamp"),
+					"count":   Match("even"),
+					"meta":    Match("any"),
+				},
+			},
+		},
+		{
+			name: "Not matching",
+			res: Response{
+				JSONPaths: map[string]interface{}{
+					"$.created": Match("uuid"),
+					"$.id":      Match("timestamp"),
+					"$.count":   Match("odd"),
+				},
+			},
+			expectErrors: []string{
+				`Got unexpected JSON response body: $.count: got 4, not matching odd: no matcher registered as "odd"`,
+				`Got unexpected JSON response body: $.created: got "2020-02-29T12:00:00.5Z", not matching uuid: not a UUID`,
+				`Got unexpected JSON response body: $.id: got "123e4567-e89b-12d3-a456-426614174000", not matching timestamp: not an RFC 3339 timestamp`,
+			},
+		},
+	}
+	for _, tc := range tt {
+		t.Run(tc.name, func(t *testing.T) {
+			rec := httptest.NewRecorder()
+			rec.Header().Set("Content-Type", "application/json")
+
+			var m mock
+			ex := &exchange{rec: rec, body: []byte(body)}
+			assertBodyValue(&m, ex, &tc.res)
+			assertJSONPaths(&m, ex, &tc.res)
+			if !reflect.DeepEqual(m.errors, tc.expectErrors) {
+				t.Errorf("Got %q, expected %q", m.errors, tc.expectErrors)
+			}
+		})
+	}
+
+	t.Run("From YAML", func(t *testing.T) {
+		var res Response
+		y := `bodyjson: '{"id": "{match:uuid}", "created": "{match:timestamp}", "count": "{match:even}", "meta": {"trace": ["{match:any}"]}}'`
+		if err := yaml.Unmarshal([]byte(y), &res); err != nil {
+			t.Fatalf("Got %s, expected nil", err)
+		}
+		var m mock
+		assertBodyJSON(&m, &exchange{body: []byte(body)}, &res)
+		if m.errored {
+			t.Errorf("Got %q, expected no errors", m.errors)
+		}
+	})
+}
@@ -0,0 +1,189 @@
+package handlertest
+
+import (
+	"net/http"
+	"path"
+	"strings"
+	"time"
+)
+
+// Option configures a Runner.
+type Option func(*Runner)
+
+// Runner runs test cases like Run and RunFromYAML do, but with options
+// applied. The zero value is ready to use.
+type Runner struct {
+	failFast bool
+	seeder   func(seed int64)
+	clock    func(now time.Time)
+	summary  bool
+	server   bool
+
+	lateWrites     bool
+	lateWriteGrace time.Duration
+	slowThreshold  time.Duration
+	maxBuffered    int
+	mutators       []mutator
+
+	forbiddenBodyPatterns []string
+	bodyIgnore            func(b []byte) []byte
+	invariantHeaders      []string
+	update                bool
+	versionFunc           func(r *http.Request, version string)
+}
+
+// New returns a Runner configured with opts.
+func New(opts ...Option) *Runner {
+	var r Runner
+	for _, opt := range opts {
+		opt(&r)
+	}
+	return &r
+}
+
+// WithFailFastWithinCase makes the Runner stop asserting a test case after its
+// first failed assertion. By default, every assertion of a test case is
+// evaluated, so all failures are reported at once.
+func WithFailFastWithinCase() Option {
+	return func(r *Runner) {
+		r.failFast = true
+	}
+}
+
+// WithSeeder registers a function that is called with Request.Seed before a
+// request with a seed is fired. It allows seeding a random number generator
+// the handler uses, if the handler does not obtain one through Rand.
+func WithSeeder(f func(seed int64)) Option {
+	return func(r *Runner) {
+		r.seeder = f
+	}
+}
+
+// WithClock registers a function that is called with Request.Now before a
+// request with a fixed time is fired. It allows setting the clock the handler
+// uses, if the handler does not obtain the time through Now.
+func WithClock(f func(now time.Time)) Option {
+	return func(r *Runner) {
+		r.clock = f
+	}
+}
+
+// WithSummary makes the Runner log a one-line summary of the number of passed,
+// failed and skipped test cases after running them.
+func WithSummary() Option {
+	return func(r *Runner) {
+		r.summary = true
+	}
+}
+
+// WithLateWriteDetection makes the Runner flag test cases of which the handler
+// writes to the ResponseWriter after returning, e.g. from a goroutine it
+// spawned. Such writes are not passed on to the recorder, but fail with an
+// error. After the handler returns, the Runner waits up to grace for a late
+// write before continuing. It has no effect when combined with WithServer.
+func WithLateWriteDetection(grace time.Duration) Option {
+	return func(r *Runner) {
+		r.lateWrites = true
+		r.lateWriteGrace = grace
+	}
+}
+
+// WithSlowThreshold makes the Runner log every test case that takes longer
+// than d to respond, along with its duration. This is informational only: slow
+// test cases do not fail. See also WithSummary.
+func WithSlowThreshold(d time.Duration) Option {
+	return func(r *Runner) {
+		r.slowThreshold = d
+	}
+}
+
+// WithMaxBufferedBytes makes the Runner flag test cases of which the handler
+// writes more than n bytes without flushing in between. Handlers that stream
+// large responses are expected to flush regularly, rather than have the
+// entire response buffered in memory. It has no effect when combined with
+// WithServer.
+func WithMaxBufferedBytes(n int) Option {
+	return func(r *Runner) {
+		r.maxBuffered = n
+	}
+}
+
+// WithForbiddenBodyPatterns makes the Runner assert the patterns, regular
+// expressions, do not match the body of any error response, in addition to
+// the ForbiddenBodyPatterns of each test case. See
+// Response.ForbiddenBodyPatterns.
+func WithForbiddenBodyPatterns(patterns ...string) Option {
+	return func(r *Runner) {
+		r.forbiddenBodyPatterns = append(r.forbiddenBodyPatterns, patterns...)
+	}
+}
+
+// WithInvariantHeader makes the Runner assert that every test case of a run
+// responds with the same value for the header name, e.g. X-API-Version, to
+// catch configuration drift across endpoints. A missing header counts as an
+// empty value. The differing values are reported once the run completes,
+// along with the test cases that responded with each.
+func WithInvariantHeader(name string) Option {
+	return func(r *Runner) {
+		r.invariantHeaders = append(r.invariantHeaders, name)
+	}
+}
+
+// WithUpdate makes the Runner write the actual body of every test case with a
+// Response.BodyFile to that file, rather than asserting it, to regenerate
+// golden files after an intentional change. The other assertions are still
+// evaluated. Setting the HANDLERTEST_UPDATE environment variable has the same
+// effect for every Runner, e.g.
+//
+//	HANDLERTEST_UPDATE=1 go test ./...
+func WithUpdate() Option {
+	return func(r *Runner) {
+		r.update = true
+	}
+}
+
+// WithBodyIgnore makes the Runner pass both the actual and the expected body
+// through f before asserting these, e.g. to zero out a timestamp in a binary
+// format. The expected body is any of Body, BodyBase64, BodyHex and
+// BodyFile. f receives a copy it may modify, and returns the normalized body.
+// Failures show the bodies as normalized. The actual body is normalized after
+// it is decompressed or decrypted, and before any other assertion, so these
+// all see the normalized body.
+func WithBodyIgnore(f func(b []byte) []byte) Option {
+	return func(r *Runner) {
+		r.bodyIgnore = f
+	}
+}
+
+// WithRequestMutator makes the Runner call f with every request of which the
+// method equals method and the URL path matches pattern, after the request is
+// built from the test case and before it is fired. An empty method matches any
+// method. A pattern that ends in a slash matches every path beneath it, like
+// http.ServeMux does (e.g. "/admin/"); any other pattern is matched with
+// path.Match (e.g. "/users/*/avatar").
+//
+// If multiple mutators match a request, all are applied in the order they were
+// passed to New, so the last one takes precedence where they conflict.
+func WithRequestMutator(method, pattern string, f func(r *http.Request)) Option {
+	return func(r *Runner) {
+		r.mutators = append(r.mutators, mutator{method: method, pattern: pattern, f: f})
+	}
+}
+
+// mutator is a request mutator registered through WithRequestMutator.
+type mutator struct {
+	method  string
+	pattern string
+	f       func(r *http.Request)
+}
+
+func (m mutator) matches(r *http.Request) bool {
+	if m.method != "" && !strings.EqualFold(m.method, r.Method) {
+		return false
+	}
+	if strings.HasSuffix(m.pattern, "/") {
+		return strings.HasPrefix(r.URL.Path, m.pattern)
+	}
+	ok, _ := path.Match(m.pattern, r.URL.Path)
+	return ok
+}
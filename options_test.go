@@ -0,0 +1,234 @@
+package handlertest
+
+import (
+	"encoding/base64"
+	"encoding/hex"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestWithSummary(t *testing.T) {
+	var m mock
+	m.runFunc = func(name string, f func(t *testing.T)) bool {
+		// Pretend the case was filtered out through -run.
+		return true
+	}
+	pass := TestCase{Request: Request{Method: http.MethodGet, URL: "/"}}
+	fail := TestCase{
+		Request:  Request{Method: http.MethodGet, URL: "/"},
+		Response: Response{Code: http.StatusTeapot},
+	}
+	skip := TestCase{Name: "skipped", Request: Request{Method: http.MethodGet, URL: "/"}}
+	New(WithSummary()).Run(&m, emptyHandler, pass, pass, fail, skip)
+
+	if len(m.logs) != 1 {
+		t.Fatalf("Got %d, expected 1", len(m.logs))
+	}
+	if exp := "handlertest: 2 passed, 1 failed, 1 skipped"; m.logs[0] != exp {
+		t.Errorf("Got %q, expected %q", m.logs[0], exp)
+	}
+}
+
+func TestWithSlowThreshold(t *testing.T) {
+	h := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/slow" {
+			time.Sleep(20 * time.Millisecond)
+		}
+	})
+
+	var m mock
+	New(WithSlowThreshold(10*time.Millisecond), WithSummary()).Run(&m, h,
+		TestCase{Request: Request{Method: http.MethodGet, URL: "/fast"}},
+		TestCase{Request: Request{Method: http.MethodGet, URL: "/slow"}},
+	)
+
+	if m.errored {
+		t.Errorf("Got %q, expected no errors", m.errors)
+	}
+	if len(m.logs) != 2 {
+		t.Fatalf("Got %q, expected 2 logs", m.logs)
+	}
+	if !strings.HasPrefix(m.logs[0], "handlertest: test case #1 took ") {
+		t.Errorf("Got %q, expected it to report test case #1", m.logs[0])
+	}
+	if exp := "handlertest: 2 passed, 0 failed, 0 skipped, 1 slow"; m.logs[1] != exp {
+		t.Errorf("Got %q, expected %q", m.logs[1], exp)
+	}
+}
+
+func TestWithRequestMutator(t *testing.T) {
+	h := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte("role=" + r.Header.Get("X-Role")))
+	})
+	setRole := func(role string) func(r *http.Request) {
+		return func(r *http.Request) {
+			r.Header.Set("X-Role", role)
+		}
+	}
+	rn := New(
+		WithRequestMutator("", "/admin/", setRole("admin")),
+		WithRequestMutator(http.MethodDelete, "/admin/users/*", setRole("superuser")),
+		WithRequestMutator("", "/reports/*/export", setRole("auditor")),
+	)
+
+	tt := []struct {
+		method string
+		url    string
+
+		expectRole string
+	}{
+		{http.MethodGet, "/users/1", ""},
+		{http.MethodGet, "/admin/users/1", "admin"},
+		{http.MethodGet, "/admin/", "admin"},
+		{http.MethodGet, "/admin", ""},
+		{http.MethodDelete, "/admin/users/1", "superuser"},
+		{http.MethodDelete, "/admin/users/1/sessions", "admin"},
+		{http.MethodGet, "/reports/2020/export?format=csv", "auditor"},
+	}
+	for _, tc := range tt {
+		var m mock
+		rn.Run(&m, h, TestCase{
+			Request:  Request{Method: tc.method, URL: tc.url},
+			Response: Response{Body: "role=" + tc.expectRole},
+		})
+		if m.errored {
+			t.Errorf("%s %s: Got %q, expected no errors", tc.method, tc.url, m.errors)
+		}
+	}
+}
+
+func TestWithForbiddenBodyPatterns(t *testing.T) {
+	h := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "open /etc/app/secrets.yaml: permission denied", http.StatusInternalServerError)
+	})
+	tc := TestCase{
+		Request: Request{Method: http.MethodGet, URL: "/"},
+		Response: Response{
+			Code:                  http.StatusInternalServerError,
+			ForbiddenBodyPatterns: []string{"permission denied"},
+		},
+	}
+
+	var m mock
+	New(WithForbiddenBodyPatterns(`/etc/`)).Run(&m, h, tc)
+	if len(m.errors) != 2 {
+		t.Errorf("Got %q, expected 2 errors", m.errors)
+	}
+	if tc.Response.ForbiddenBodyPatterns[0] != "permission denied" || len(tc.Response.ForbiddenBodyPatterns) != 1 {
+		t.Errorf("Got %q, expected the test case to be left intact", tc.Response.ForbiddenBodyPatterns)
+	}
+}
+
+func TestWithBodyIgnore(t *testing.T) {
+	// The body is a 1-byte version, followed by an 8-byte timestamp and the
+	// payload.
+	h := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte("\x01" + time.Now().Format("15:04:05") + "payload"))
+	})
+	ignoreTimestamp := func(b []byte) []byte {
+		for i := 1; i < 9 && i < len(b); i++ {
+			b[i] = 0
+		}
+		return b
+	}
+
+	t.Run("Equal after ignoring", func(t *testing.T) {
+		var m mock
+		New(WithBodyIgnore(ignoreTimestamp)).Run(&m, h, TestCase{
+			Request:  Request{Method: http.MethodGet, URL: "/"},
+			Response: Response{Body: "\x0100:00:00payload"},
+		})
+		if m.errored {
+			t.Errorf("Got %q, expected no errors", m.errors)
+		}
+	})
+
+	t.Run("Different after ignoring", func(t *testing.T) {
+		var m mock
+		New(WithBodyIgnore(ignoreTimestamp)).Run(&m, h, TestCase{
+			Request:  Request{Method: http.MethodGet, URL: "/"},
+			Response: Response{Body: "\x02XXXXXXXXpayload"},
+		})
+		exp := []string{`Got response body "\x01\x00\x00\x00\x00\x00\x00\x00\x00payload", expected "\x02\x00\x00\x00\x00\x00\x00\x00\x00payload"`}
+		if !reflect.DeepEqual(m.errors, exp) {
+			t.Errorf("Got %q, expected %q", m.errors, exp)
+		}
+	})
+
+	t.Run("Binary expectations", func(t *testing.T) {
+		dir, err := ioutil.TempDir("", "handlertest")
+		if err != nil {
+			t.Fatalf("Got %s, expected nil", err)
+		}
+		defer os.RemoveAll(dir)
+		file := filepath.Join(dir, "body.bin")
+		if err := ioutil.WriteFile(file, []byte("\x0112:34:56payload"), 0644); err != nil {
+			t.Fatalf("Got %s, expected nil", err)
+		}
+
+		for _, res := range []Response{
+			{BodyHex: hex.EncodeToString([]byte("\x0112:34:56payload"))},
+			{BodyBase64: base64.StdEncoding.EncodeToString([]byte("\x0112:34:56payload"))},
+			{BodyFile: file},
+		} {
+			var m mock
+			New(WithBodyIgnore(ignoreTimestamp)).Run(&m, h, TestCase{
+				Request:  Request{Method: http.MethodGet, URL: "/"},
+				Response: res,
+			})
+			if m.errored {
+				t.Errorf("Got %q, expected no errors", m.errors)
+			}
+		}
+	})
+}
+
+func TestWithInvariantHeader(t *testing.T) {
+	h := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/legacy":
+			w.Header().Set("X-API-Version", "1")
+		case "/bare":
+		default:
+			w.Header().Set("X-API-Version", "2")
+		}
+	})
+	get := func(url string) TestCase {
+		return TestCase{Request: Request{Method: http.MethodGet, URL: url}}
+	}
+
+	tt := []struct {
+		name string
+
+		tcs []TestCase
+
+		expectErrors []string
+	}{
+		{
+			name: "Uniform",
+			tcs:  []TestCase{get("/a"), get("/b")},
+		},
+		{
+			name: "Differing",
+			tcs:  []TestCase{get("/a"), get("/legacy"), get("/b"), get("/bare")},
+			expectErrors: []string{
+				`Got differing X-API-Version headers across test cases: "" from test case #3; "1" from test case #1; "2" from test case #0, test case #2`,
+			},
+		},
+	}
+	for _, tc := range tt {
+		t.Run(tc.name, func(t *testing.T) {
+			var m mock
+			New(WithInvariantHeader("X-API-Version")).Run(&m, h, tc.tcs...)
+			if !reflect.DeepEqual(m.errors, tc.expectErrors) {
+				t.Errorf("Got %q, expected %q", m.errors, tc.expectErrors)
+			}
+		})
+	}
+}
@@ -0,0 +1,72 @@
+package handlertest
+
+import (
+	"fmt"
+	"net/http"
+	"testing"
+)
+
+// RunParallelIsolation runs the test cases in parallel, each against a fresh
+// handler created by factory, to catch cross-case interference such as shared
+// state leaking between requests. The outcome of every case is first recorded
+// in isolation, by running the cases one by one. Then the cases run in
+// parallel, and any case whose status code or body differs from its isolated
+// outcome is reported, as it depended on concurrency. Run with -race to also
+// catch data races. Unnamed test cases are named after their index. As the
+// cases run in isolation, values cannot be captured between them.
+func RunParallelIsolation(t tt, factory func() http.Handler, tcs ...TestCase) {
+	isolated := make([]*outcome, len(tcs))
+	for i, tc := range tcs {
+		s := New().newSession(factory())
+		ex := s.run(&failureT{tt: discardT{t}}, tc)
+		s.close()
+		if ex != nil {
+			isolated[i] = &outcome{code: ex.rec.Code, body: string(ex.body)}
+		}
+	}
+
+	t.Run("parallel", func(t *testing.T) {
+		for i, tc := range tcs {
+			i, tc := i, tc
+			name := tc.Name
+			if name == "" {
+				name = fmt.Sprintf("#%d", i)
+			}
+			t.Run(name, func(t *testing.T) {
+				t.Parallel()
+
+				s := New().newSession(factory())
+				defer s.close()
+				if ex := s.run(t, tc); ex != nil && isolated[i] != nil {
+					isolated[i].assertSame(t, ex)
+				}
+			})
+		}
+	})
+}
+
+// outcome is the essence of a response.
+type outcome struct {
+	code int
+	body string
+}
+
+// assertSame flags t as failed if ex, recorded in parallel, has a different
+// outcome than o, recorded in isolation.
+func (o *outcome) assertSame(t tt, ex *exchange) {
+	if ex.rec.Code != o.code || string(ex.body) != o.body {
+		t.Errorf("Got response code %d and body %q in parallel, but %d and %q in isolation: the outcome depends on concurrency",
+			ex.rec.Code, ex.body, o.code, o.body)
+	}
+}
+
+// discardT is a tt that ignores failures, as they are reported elsewhere.
+type discardT struct {
+	tt
+}
+
+func (discardT) Errorf(format string, args ...interface{}) {}
+func (discardT) Fatalf(format string, args ...interface{}) {}
+
+// Run does not run f, as its failures could not be discarded.
+func (discardT) Run(name string, f func(t *testing.T)) bool { return true }
@@ -0,0 +1,53 @@
+package handlertest
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestRunParallelIsolation(t *testing.T) {
+	tcs := []TestCase{
+		{Name: "foo", Request: Request{Method: http.MethodGet, URL: "/?name=foo"}, Response: Response{Body: "Hello foo"}},
+		{Name: "bar", Request: Request{Method: http.MethodGet, URL: "/?name=bar"}, Response: Response{Body: "Hello bar"}},
+	}
+
+	t.Run("Isolated", func(t *testing.T) {
+		factory := func() http.Handler {
+			return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				_, _ = fmt.Fprintf(w, "Hello %s", r.URL.Query().Get("name"))
+			})
+		}
+
+		var m mock
+		m.runFunc = t.Run
+		RunParallelIsolation(&m, factory, tcs...)
+	})
+}
+
+func TestOutcomeAssertSame(t *testing.T) {
+	o := &outcome{code: http.StatusOK, body: "Hello foo"}
+
+	tt := []struct {
+		name string
+
+		code int
+		body string
+
+		expectError bool
+	}{
+		{name: "Same", code: http.StatusOK, body: "Hello foo"},
+		{name: "Different code", code: http.StatusConflict, body: "Hello foo", expectError: true},
+		{name: "Different body", code: http.StatusOK, body: "Hello bar", expectError: true},
+	}
+	for _, tc := range tt {
+		t.Run(tc.name, func(t *testing.T) {
+			var m mock
+			o.assertSame(&m, &exchange{rec: &httptest.ResponseRecorder{Code: tc.code}, body: []byte(tc.body)})
+			if m.errored != tc.expectError {
+				t.Errorf("Got %t, expected %t", m.errored, tc.expectError)
+			}
+		})
+	}
+}
@@ -0,0 +1,59 @@
+package handlertest
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+// allocRuns is the number of times AssertAllocs fires the request.
+const allocRuns = 100
+
+// AssertAllocs fires the request of tc at h a number of times, and flags t as
+// failed if serving it allocated more than maxAllocs times on average. Only
+// allocations made by h count: requests and recorders are created up front,
+// and the allocations of the recorder itself, e.g. to buffer the body, are
+// measured by replaying the response h wrote, and subtracted. Note that the
+// race detector inflates allocation counts.
+func AssertAllocs(t tt, h http.Handler, tc TestCase, maxAllocs uint64) {
+	rec, err := record(h, &tc.Request)
+	if err != nil {
+		t.Errorf("Cannot measure allocations: %s", err)
+		return
+	}
+	code, hdr, body := rec.Code, rec.Result().Header, rec.Body.Bytes()
+	replay := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		dst := w.Header()
+		for k, v := range hdr {
+			dst[k] = v
+		}
+		w.WriteHeader(code)
+		_, _ = w.Write(body)
+	})
+
+	allocs := allocsPerRequest(h, &tc.Request) - allocsPerRequest(replay, &tc.Request)
+	if allocs < 0 {
+		allocs = 0
+	}
+	if uint64(allocs) > maxAllocs {
+		t.Errorf("Got %.0f allocations per request, expected at most %d", allocs, maxAllocs)
+	}
+}
+
+// allocsPerRequest returns the average number of allocations of serving req
+// with h, including those of the recorder.
+func allocsPerRequest(h http.Handler, req *Request) float64 {
+	// AllocsPerRun does a warm-up run before the measured runs.
+	reqs := make([]*http.Request, allocRuns+1)
+	recs := make([]*httptest.ResponseRecorder, allocRuns+1)
+	for i := range reqs {
+		reqs[i] = httpRequest(req)
+		recs[i] = httptest.NewRecorder()
+	}
+
+	var i int
+	return testing.AllocsPerRun(allocRuns, func() {
+		h.ServeHTTP(recs[i], reqs[i])
+		i++
+	})
+}
@@ -0,0 +1,54 @@
+package handlertest
+
+import (
+	"net/http"
+	"testing"
+)
+
+func TestAssertAllocs(t *testing.T) {
+	var sink [][]byte
+	h := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		for i := 0; i < 10; i++ {
+			sink = append(sink, make([]byte, 1024))
+		}
+	})
+	tc := TestCase{Request: Request{Method: http.MethodGet, URL: "/"}}
+
+	t.Run("Within cap", func(t *testing.T) {
+		var m mock
+		AssertAllocs(&m, emptyHandler, tc, 0)
+
+		if m.errored {
+			t.Errorf("Got %q, expected no errors", m.errors)
+		}
+	})
+
+	t.Run("Writing a body", func(t *testing.T) {
+		body := []byte("ok")
+		for _, contentType := range [][]string{nil, {"text/plain"}} {
+			contentType := contentType
+			h := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				if contentType != nil {
+					w.Header()["Content-Type"] = contentType
+				}
+				w.WriteHeader(http.StatusCreated)
+				_, _ = w.Write(body)
+			})
+
+			var m mock
+			AssertAllocs(&m, h, tc, 0)
+			if m.errored {
+				t.Errorf("Got %q, expected no errors", m.errors)
+			}
+		}
+	})
+
+	t.Run("Exceeding cap", func(t *testing.T) {
+		var m mock
+		AssertAllocs(&m, h, tc, 5)
+
+		if !m.errored {
+			t.Errorf("Got false, expected true")
+		}
+	})
+}
@@ -0,0 +1,68 @@
+package handlertest
+
+import (
+	"encoding/json"
+	"mime"
+)
+
+// Problem describes an expected RFC 7807 Problem Details response body.
+// Fields that are not set are not asserted.
+type Problem struct {
+	Type     string
+	Title    string
+	Status   int
+	Detail   string
+	Instance string
+}
+
+func assertProblem(t tt, ex *exchange, res *Response) {
+	if res.Problem == nil {
+		return
+	}
+	ct := ex.rec.Result().Header.Get("Content-Type")
+	if mt, _, err := mime.ParseMediaType(ct); err != nil || mt != "application/problem+json" {
+		t.Errorf("Got Content-Type %q, expected application/problem+json", ct)
+	}
+
+	var p struct {
+		Type     *string `json:"type"`
+		Title    *string `json:"title"`
+		Status   *int    `json:"status"`
+		Detail   *string `json:"detail"`
+		Instance *string `json:"instance"`
+	}
+	if err := json.Unmarshal(ex.body, &p); err != nil {
+		t.Errorf("Got invalid Problem Details response body: %s", err)
+		return
+	}
+
+	exp := res.Problem
+	fields := []struct {
+		name     string
+		got      *string
+		expected string
+	}{
+		{"type", p.Type, exp.Type},
+		{"title", p.Title, exp.Title},
+		{"detail", p.Detail, exp.Detail},
+		{"instance", p.Instance, exp.Instance},
+	}
+	for _, f := range fields {
+		switch {
+		case f.expected == "":
+		case f.got == nil:
+			t.Errorf("Missing Problem Details field %q, expected %q", f.name, f.expected)
+		case *f.got != f.expected:
+			t.Errorf("Got Problem Details field %q %q, expected %q", f.name, *f.got, f.expected)
+		}
+	}
+	switch {
+	case p.Status != nil && *p.Status != ex.rec.Code:
+		t.Errorf("Got Problem Details status %d, expected it to match response code %d", *p.Status, ex.rec.Code)
+	case exp.Status == 0:
+	case p.Status == nil:
+		t.Errorf("Missing Problem Details field %q, expected %d", "status", exp.Status)
+	case *p.Status != exp.Status:
+		t.Errorf("Got Problem Details field %q %d, expected %d", "status", *p.Status, exp.Status)
+	}
+}
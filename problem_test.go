@@ -0,0 +1,65 @@
+package handlertest
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestAssertProblem(t *testing.T) {
+	problemJSON := http.Header{"Content-Type": {"application/problem+json; charset=utf-8"}}
+	body := `{"type": "https://example.com/probs/out-of-credit", "title": "You do not have enough credit.", "status": 403}`
+	exp := &Problem{
+		Type:   "https://example.com/probs/out-of-credit",
+		Status: http.StatusForbidden,
+	}
+
+	tt := []struct {
+		name string
+
+		rec *httptest.ResponseRecorder
+		exp *Problem
+
+		expectErrors int
+	}{
+		{
+			name: "Matching",
+			rec:  &httptest.ResponseRecorder{Code: http.StatusForbidden, HeaderMap: problemJSON, Body: bytes.NewBufferString(body)},
+			exp:  exp,
+		},
+		{
+			name:         "Wrong content type",
+			rec:          &httptest.ResponseRecorder{Code: http.StatusForbidden, Body: bytes.NewBufferString(body)},
+			exp:          exp,
+			expectErrors: 1,
+		},
+		{
+			name:         "Field mismatch and missing",
+			rec:          &httptest.ResponseRecorder{Code: http.StatusForbidden, HeaderMap: problemJSON, Body: bytes.NewBufferString(body)},
+			exp:          &Problem{Title: "Out of credit", Detail: "Your balance is 30"},
+			expectErrors: 2,
+		},
+		{
+			name:         "Status not matching code",
+			rec:          &httptest.ResponseRecorder{Code: http.StatusBadRequest, HeaderMap: problemJSON, Body: bytes.NewBufferString(body)},
+			exp:          &Problem{},
+			expectErrors: 1,
+		},
+		{
+			name:         "Invalid JSON",
+			rec:          &httptest.ResponseRecorder{Code: http.StatusForbidden, HeaderMap: problemJSON, Body: bytes.NewBufferString("Forbidden")},
+			exp:          &Problem{},
+			expectErrors: 1,
+		},
+	}
+	for _, tc := range tt {
+		t.Run(tc.name, func(t *testing.T) {
+			var m mock
+			assertProblem(&m, &exchange{rec: tc.rec, body: tc.rec.Body.Bytes()}, &Response{Problem: tc.exp})
+			if len(m.errors) != tc.expectErrors {
+				t.Errorf("Got %q, expected %d errors", m.errors, tc.expectErrors)
+			}
+		})
+	}
+}
@@ -0,0 +1,108 @@
+package handlertest
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"mime"
+	"mime/multipart"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// ByteRange describes an expected part of a multipart/byteranges response.
+// Fields that are not set are not asserted.
+type ByteRange struct {
+	// ContentRange is the expected Content-Range of the part, e.g.
+	// "bytes 0-9/100".
+	ContentRange string
+	// ContentType is the expected Content-Type of the part.
+	ContentType string
+	// Body is the expected content of the part.
+	Body string
+}
+
+// MultiRangeRequest returns a GET request for url with a Range header for
+// ranges, e.g. MultiRangeRequest("/file", "0-9", "20-29").
+func MultiRangeRequest(url string, ranges ...string) Request {
+	return Request{
+		Method:  http.MethodGet,
+		URL:     url,
+		Headers: []string{"Range: bytes=" + strings.Join(ranges, ",")},
+	}
+}
+
+// SuffixRangeCase returns a test case that requests the last n bytes of url,
+// a resource of size bytes, with a suffix range such as "bytes=-500". The
+// handler is expected to respond with 206 Partial Content, and a
+// Content-Range and Content-Length computed from size. If n exceeds size,
+// the entire resource is expected, as the spec requires. n must be positive,
+// as a suffix range of zero bytes cannot be satisfied.
+func SuffixRangeCase(url string, size, n int) TestCase {
+	first := size - n
+	if first < 0 {
+		first = 0
+	}
+	return TestCase{
+		Name: fmt.Sprintf("GET %s with Range bytes=-%d", url, n),
+		Request: Request{
+			Method:  http.MethodGet,
+			URL:     url,
+			Headers: []string{"Range: bytes=-" + strconv.Itoa(n)},
+		},
+		Response: Response{
+			Code: http.StatusPartialContent,
+			Headers: []string{
+				fmt.Sprintf("Content-Range: bytes %d-%d/%d", first, size-1, size),
+				"Content-Length: " + strconv.Itoa(size-first),
+			},
+		},
+	}
+}
+
+func assertByteRanges(t tt, ex *exchange, res *Response) {
+	if res.ByteRanges == nil {
+		return
+	}
+	ct := ex.rec.Result().Header.Get("Content-Type")
+	mt, params, err := mime.ParseMediaType(ct)
+	if err != nil || mt != "multipart/byteranges" || params["boundary"] == "" {
+		t.Errorf("Got Content-Type %q, expected multipart/byteranges with a boundary", ct)
+		return
+	}
+
+	mr := multipart.NewReader(bytes.NewReader(ex.body), params["boundary"])
+	var i int
+	for ; ; i++ {
+		p, err := mr.NextPart()
+		if err != nil {
+			if err != io.EOF {
+				t.Errorf("Cannot read part %d: %s", i, err)
+			}
+			break
+		}
+		if i >= len(res.ByteRanges) {
+			continue
+		}
+		exp := res.ByteRanges[i]
+		if cr := p.Header.Get("Content-Range"); exp.ContentRange != "" && cr != exp.ContentRange {
+			t.Errorf("Got part %d Content-Range %q, expected %q", i, cr, exp.ContentRange)
+		}
+		if ct := p.Header.Get("Content-Type"); exp.ContentType != "" && ct != exp.ContentType {
+			t.Errorf("Got part %d Content-Type %q, expected %q", i, ct, exp.ContentType)
+		}
+		b, err := ioutil.ReadAll(p)
+		if err != nil {
+			t.Errorf("Cannot read part %d: %s", i, err)
+			continue
+		}
+		if exp.Body != "" && string(b) != exp.Body {
+			t.Errorf("Got part %d body %q, expected %q", i, b, exp.Body)
+		}
+	}
+	if i != len(res.ByteRanges) {
+		t.Errorf("Got %d parts, expected %d", i, len(res.ByteRanges))
+	}
+}
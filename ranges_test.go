@@ -0,0 +1,139 @@
+package handlertest
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"reflect"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestByteRanges(t *testing.T) {
+	content := "0123456789abcdefghijklmnopqrstuvwxyz"
+	h := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/plain")
+		http.ServeContent(w, r, "file.txt", time.Time{}, strings.NewReader(content))
+	})
+	req := MultiRangeRequest("/file.txt", "0-9", "20-29")
+
+	t.Run("Matching parts", func(t *testing.T) {
+		var m mock
+		Run(&m, h, TestCase{
+			Request: req,
+			Response: Response{
+				Code: http.StatusPartialContent,
+				ByteRanges: []ByteRange{
+					{ContentRange: "bytes 0-9/36", ContentType: "text/plain", Body: "0123456789"},
+					{ContentRange: "bytes 20-29/36", Body: "klmnopqrst"},
+				},
+			},
+		})
+		if m.errored {
+			t.Errorf("Got %q, expected no errors", m.errors)
+		}
+	})
+
+	t.Run("Diverging part", func(t *testing.T) {
+		var m mock
+		Run(&m, h, TestCase{
+			Request: req,
+			Response: Response{
+				Code: http.StatusPartialContent,
+				ByteRanges: []ByteRange{
+					{Body: "0123456789"},
+					{ContentRange: "bytes 20-29/37", Body: "klmnopqrsT"},
+				},
+			},
+		})
+		if len(m.errors) != 2 {
+			t.Errorf("Got %q, expected 2 errors", m.errors)
+		}
+	})
+
+	t.Run("Part count mismatch", func(t *testing.T) {
+		var m mock
+		Run(&m, h, TestCase{
+			Request: req,
+			Response: Response{
+				Code:       http.StatusPartialContent,
+				ByteRanges: []ByteRange{{Body: "0123456789"}},
+			},
+		})
+		if len(m.errors) != 1 {
+			t.Errorf("Got %q, expected 1 error", m.errors)
+		}
+	})
+
+	t.Run("Not multipart", func(t *testing.T) {
+		var m mock
+		Run(&m, h, TestCase{
+			Request: MultiRangeRequest("/file.txt", "0-9"),
+			Response: Response{
+				Code:       http.StatusPartialContent,
+				ByteRanges: []ByteRange{{Body: "0123456789"}},
+			},
+		})
+		if len(m.errors) != 1 {
+			t.Errorf("Got %q, expected 1 error", m.errors)
+		}
+	})
+}
+
+func TestSuffixRangeCase(t *testing.T) {
+	content := "0123456789abcdefghijklmnopqrstuvwxyz"
+	serve := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.ServeContent(w, r, "file.txt", time.Time{}, strings.NewReader(content))
+	})
+	// fromStart wrongly serves the first n bytes for a suffix range.
+	fromStart := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		n, _ := strconv.Atoi(strings.TrimPrefix(r.Header.Get("Range"), "bytes=-"))
+		w.Header().Set("Content-Range", fmt.Sprintf("bytes 0-%d/%d", n-1, len(content)))
+		w.Header().Set("Content-Length", strconv.Itoa(n))
+		w.WriteHeader(http.StatusPartialContent)
+		_, _ = io.WriteString(w, content[:n])
+	})
+
+	tt := []struct {
+		name string
+
+		h http.Handler
+		n int
+
+		expectErrors []string
+	}{
+		{
+			name: "Tail",
+			h:    serve,
+			n:    10,
+		},
+		{
+			name: "Exceeding size",
+			h:    serve,
+			n:    100,
+		},
+		{
+			name:         "Served from start",
+			h:            fromStart,
+			n:            10,
+			expectErrors: []string{`Got response header Content-Range "bytes 0-9/36", expected "bytes 26-35/36"`},
+		},
+	}
+	for _, tc := range tt {
+		t.Run(tc.name, func(t *testing.T) {
+			c := SuffixRangeCase("/file.txt", len(content), tc.n)
+			if exp := fmt.Sprintf("GET /file.txt with Range bytes=-%d", tc.n); c.Name != exp {
+				t.Errorf("Got %q, expected %q", c.Name, exp)
+			}
+			c.Name = ""
+
+			var m mock
+			Run(&m, tc.h, c)
+			if !reflect.DeepEqual(m.errors, tc.expectErrors) {
+				t.Errorf("Got %q, expected %q", m.errors, tc.expectErrors)
+			}
+		})
+	}
+}
@@ -0,0 +1,258 @@
+package handlertest
+
+import (
+	"fmt"
+	"io/ioutil"
+	"math"
+	"reflect"
+	"regexp"
+	"strings"
+	"unicode/utf8"
+)
+
+func assertJSONSchema(t tt, ex *exchange, res *Response) {
+	if res.JSONSchema == "" {
+		return
+	}
+	b, err := ioutil.ReadFile(res.JSONSchema)
+	if err != nil {
+		t.Errorf("Cannot read JSON schema: %s", err)
+		return
+	}
+	schema, err := decodeJSON(b)
+	if err != nil {
+		t.Errorf("Invalid JSON schema %s: %s", res.JSONSchema, err)
+		return
+	}
+	v, err := decodeJSON(ex.body)
+	if err != nil {
+		t.Errorf("Got invalid JSON response body: %s", err)
+		return
+	}
+
+	sv := schemaValidator{root: schema}
+	for _, msg := range sv.validate(schema, v, nil) {
+		t.Errorf("Got response body not matching JSON schema %s: %s", res.JSONSchema, msg)
+	}
+}
+
+// schemaValidator validates decoded JSON values against a JSON Schema. See
+// Response.JSONSchema for the supported keywords.
+type schemaValidator struct {
+	root interface{}
+}
+
+// validate returns a description of every violation of schema by v, which is
+// located at path.
+func (sv *schemaValidator) validate(schema, v interface{}, path []interface{}) []string {
+	at := formatPath(path)
+	s, ok := schema.(map[string]interface{})
+	if !ok {
+		if schema == false {
+			return []string{fmt.Sprintf("%s: got %s, expected nothing to be allowed", at, formatJSON(v))}
+		}
+		return nil
+	}
+	if ref, ok := s["$ref"].(string); ok {
+		target, err := sv.resolve(ref)
+		if err != nil {
+			return []string{fmt.Sprintf("%s: %s", at, err)}
+		}
+		return sv.validate(target, v, path)
+	}
+
+	var msgs []string
+	fail := func(format string, args ...interface{}) {
+		msgs = append(msgs, at+": "+fmt.Sprintf(format, args...))
+	}
+	if typ, ok := s["type"]; ok && !matchesType(typ, v) {
+		fail("got %s, expected type %s", formatJSON(v), formatJSON(typ))
+		return msgs
+	}
+	if enum, ok := s["enum"].([]interface{}); ok && !containsValue(enum, v) {
+		fail("got %s, expected one of %s", formatJSON(v), formatJSON(enum))
+	}
+	if c, ok := s["const"]; ok && !reflect.DeepEqual(c, v) {
+		fail("got %s, expected %s", formatJSON(v), formatJSON(c))
+	}
+
+	switch v := v.(type) {
+	case map[string]interface{}:
+		msgs = append(msgs, sv.validateObject(s, v, path)...)
+	case []interface{}:
+		if n, ok := s["minItems"].(float64); ok && float64(len(v)) < n {
+			fail("got array of length %d, expected at least %v", len(v), n)
+		}
+		if n, ok := s["maxItems"].(float64); ok && float64(len(v)) > n {
+			fail("got array of length %d, expected at most %v", len(v), n)
+		}
+		for i, elem := range v {
+			switch items := s["items"].(type) {
+			case []interface{}:
+				if i < len(items) {
+					msgs = append(msgs, sv.validate(items[i], elem, appendPath(path, i))...)
+				}
+			case nil:
+			default:
+				msgs = append(msgs, sv.validate(items, elem, appendPath(path, i))...)
+			}
+		}
+	case string:
+		n := utf8.RuneCountInString(v)
+		if min, ok := s["minLength"].(float64); ok && float64(n) < min {
+			fail("got string of length %d, expected at least %v", n, min)
+		}
+		if max, ok := s["maxLength"].(float64); ok && float64(n) > max {
+			fail("got string of length %d, expected at most %v", n, max)
+		}
+		if p, ok := s["pattern"].(string); ok {
+			re, err := regexp.Compile(p)
+			if err != nil {
+				fail("invalid pattern %q: %s", p, err)
+			} else if !re.MatchString(v) {
+				fail("got %s, expected it to match %q", formatJSON(v), p)
+			}
+		}
+	case float64:
+		if min, ok := s["minimum"].(float64); ok && v < min {
+			fail("got %v, expected at least %v", v, min)
+		}
+		if max, ok := s["maximum"].(float64); ok && v > max {
+			fail("got %v, expected at most %v", v, max)
+		}
+		if min, ok := s["exclusiveMinimum"].(float64); ok && v <= min {
+			fail("got %v, expected more than %v", v, min)
+		}
+		if max, ok := s["exclusiveMaximum"].(float64); ok && v >= max {
+			fail("got %v, expected less than %v", v, max)
+		}
+	}
+
+	if all, ok := s["allOf"].([]interface{}); ok {
+		for _, sub := range all {
+			msgs = append(msgs, sv.validate(sub, v, path)...)
+		}
+	}
+	if anyOf, ok := s["anyOf"].([]interface{}); ok && sv.countValid(anyOf, v, path) == 0 {
+		fail("got %s, expected it to match at least one schema of anyOf", formatJSON(v))
+	}
+	if oneOf, ok := s["oneOf"].([]interface{}); ok {
+		if n := sv.countValid(oneOf, v, path); n != 1 {
+			fail("got %s matching %d schemas of oneOf, expected exactly one", formatJSON(v), n)
+		}
+	}
+	if not, ok := s["not"]; ok && len(sv.validate(not, v, path)) == 0 {
+		fail("got %s, expected it not to match the schema of not", formatJSON(v))
+	}
+	return msgs
+}
+
+func (sv *schemaValidator) validateObject(s, v map[string]interface{}, path []interface{}) []string {
+	var msgs []string
+	if required, ok := s["required"].([]interface{}); ok {
+		for _, k := range required {
+			if k, ok := k.(string); ok {
+				if _, ok := v[k]; !ok {
+					msgs = append(msgs, fmt.Sprintf("%s: missing, expected it to be present", formatPath(appendPath(path, k))))
+				}
+			}
+		}
+	}
+	props, _ := s["properties"].(map[string]interface{})
+	for _, k := range sortedKeys(v) {
+		p := appendPath(path, k)
+		if sub, ok := props[k]; ok {
+			msgs = append(msgs, sv.validate(sub, v[k], p)...)
+			continue
+		}
+		switch additional := s["additionalProperties"].(type) {
+		case nil:
+		case bool:
+			if !additional {
+				msgs = append(msgs, fmt.Sprintf("%s: got %s, expected it to be absent", formatPath(p), formatJSON(v[k])))
+			}
+		default:
+			msgs = append(msgs, sv.validate(additional, v[k], p)...)
+		}
+	}
+	return msgs
+}
+
+// countValid returns how many of schemas v is valid against.
+func (sv *schemaValidator) countValid(schemas []interface{}, v interface{}, path []interface{}) int {
+	var n int
+	for _, sub := range schemas {
+		if len(sv.validate(sub, v, path)) == 0 {
+			n++
+		}
+	}
+	return n
+}
+
+// resolve returns the schema ref points to, which must be a JSON pointer
+// within the root schema, such as "#/definitions/user".
+func (sv *schemaValidator) resolve(ref string) (interface{}, error) {
+	if !strings.HasPrefix(ref, "#") {
+		return nil, fmt.Errorf("unsupported $ref %q, expected a location within the schema", ref)
+	}
+	v := sv.root
+	for _, tok := range strings.Split(strings.TrimPrefix(ref, "#"), "/")[1:] {
+		tok = strings.Replace(strings.Replace(tok, "~1", "/", -1), "~0", "~", -1)
+		m, ok := v.(map[string]interface{})
+		if !ok {
+			return nil, fmt.Errorf("cannot resolve $ref %q", ref)
+		}
+		if v, ok = m[tok]; !ok {
+			return nil, fmt.Errorf("cannot resolve $ref %q", ref)
+		}
+	}
+	return v, nil
+}
+
+// matchesType reports whether v is of typ, the value of a type keyword: a
+// type name or a list of these.
+func matchesType(typ, v interface{}) bool {
+	types, ok := typ.([]interface{})
+	if !ok {
+		types = []interface{}{typ}
+	}
+	for _, t := range types {
+		switch t {
+		case schemaType(v):
+			return true
+		case "integer":
+			if f, ok := v.(float64); ok && f == math.Trunc(f) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// schemaType returns the JSON Schema type name of v.
+func schemaType(v interface{}) string {
+	switch v.(type) {
+	case map[string]interface{}:
+		return "object"
+	case []interface{}:
+		return "array"
+	case string:
+		return "string"
+	case float64:
+		return "number"
+	case bool:
+		return "boolean"
+	default:
+		return "null"
+	}
+}
+
+// containsValue reports whether vs contains a value deeply equal to v.
+func containsValue(vs []interface{}, v interface{}) bool {
+	for _, e := range vs {
+		if reflect.DeepEqual(e, v) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,96 @@
+package handlertest
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestAssertJSONSchema(t *testing.T) {
+	dir, err := ioutil.TempDir("", "handlertest")
+	if err != nil {
+		t.Fatalf("Got %s, expected nil", err)
+	}
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "user.json")
+	schema := `{
+		"type": "object",
+		"required": ["id", "name", "roles"],
+		"additionalProperties": false,
+		"properties": {
+			"id": {"type": "integer", "minimum": 1},
+			"name": {"type": "string", "minLength": 1, "maxLength": 8},
+			"email": {"type": ["string", "null"], "pattern": "^[^@]+@[^@]+$"},
+			"roles": {"type": "array", "minItems": 1, "items": {"$ref": "#/definitions/role"}},
+			"status": {"oneOf": [{"const": "active"}, {"const": "invited"}]}
+		},
+		"definitions": {
+			"role": {"enum": ["admin", "member"]}
+		}
+	}`
+	if err := ioutil.WriteFile(path, []byte(schema), 0644); err != nil {
+		t.Fatalf("Got %s, expected nil", err)
+	}
+
+	tt := []struct {
+		name string
+
+		body string
+
+		expectErrors []string
+	}{
+		{
+			name: "Valid",
+			body: `{"id": 42, "name": "Alice", "email": null, "roles": ["admin"], "status": "active"}`,
+		},
+		{
+			name: "Invalid",
+			body: `{"id": 1.5, "name": "Bartholomew", "email": "nope", "roles": ["owner"], "status": "gone", "extra": true}`,
+			expectErrors: []string{
+				"Got response body not matching JSON schema " + path + `: $.email: got "nope", expected it to match "^[^@]+@[^@]+$"`,
+				"Got response body not matching JSON schema " + path + `: $.extra: got true, expected it to be absent`,
+				"Got response body not matching JSON schema " + path + `: $.id: got 1.5, expected type "integer"`,
+				"Got response body not matching JSON schema " + path + `: $.name: got string of length 11, expected at most 8`,
+				"Got response body not matching JSON schema " + path + `: $.roles[0]: got "owner", expected one of ["admin","member"]`,
+				"Got response body not matching JSON schema " + path + `: $.status: got "gone" matching 0 schemas of oneOf, expected exactly one`,
+			},
+		},
+		{
+			name: "Missing required",
+			body: `{"id": 42, "roles": []}`,
+			expectErrors: []string{
+				"Got response body not matching JSON schema " + path + `: $.name: missing, expected it to be present`,
+				"Got response body not matching JSON schema " + path + `: $.roles: got array of length 0, expected at least 1`,
+			},
+		},
+		{
+			name: "Wrong type",
+			body: `[]`,
+			expectErrors: []string{
+				"Got response body not matching JSON schema " + path + `: $: got [], expected type "object"`,
+			},
+		},
+	}
+	for _, tc := range tt {
+		t.Run(tc.name, func(t *testing.T) {
+			var m mock
+			assertJSONSchema(&m, &exchange{body: []byte(tc.body)}, &Response{JSONSchema: path})
+			if !reflect.DeepEqual(m.errors, tc.expectErrors) {
+				t.Errorf("Got %q, expected %q", m.errors, tc.expectErrors)
+			}
+		})
+	}
+
+	t.Run("Missing schema", func(t *testing.T) {
+		var m mock
+		missing := filepath.Join(dir, "missing.json")
+		assertJSONSchema(&m, &exchange{body: []byte(`{}`)}, &Response{JSONSchema: missing})
+		exp := []string{"Cannot read JSON schema: open " + missing + ": no such file or directory"}
+		if !reflect.DeepEqual(m.errors, exp) {
+			t.Errorf("Got %q, expected %q", m.errors, exp)
+		}
+	})
+}
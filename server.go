@@ -0,0 +1,196 @@
+package handlertest
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"time"
+)
+
+// WithServer makes the Runner fire requests at a live httptest.Server that
+// serves the handler, instead of calling its ServeHTTP method directly. This
+// exercises the full net/http stack, at the cost of speed. Request context
+// values are not sent over the wire, so the handler does not see these.
+//
+// Calling ServeHTTP directly skips parsing the request from the wire, so
+// framing rules, such as how Content-Length and Transfer-Encoding are
+// reconciled, are only exercised with a live server. See
+// AssertRejectsConflictingFraming.
+func WithServer() Option {
+	return func(r *Runner) {
+		r.server = true
+	}
+}
+
+// RunServer is like Run, but fires the requests at a live server. See
+// WithServer.
+func RunServer(t tt, h http.Handler, tcs ...TestCase) {
+	New(WithServer()).Run(t, h, tcs...)
+}
+
+// newClient returns a client for srv that neither follows redirects nor
+// transparently requests compression, so handlers see the requests as
+// described and their responses are recorded as they were sent.
+func newClient(srv *httptest.Server) *http.Client {
+	c := *srv.Client()
+	if tr, ok := c.Transport.(*http.Transport); ok {
+		tr = tr.Clone()
+		tr.DisableCompression = true
+		c.Transport = tr
+	}
+	c.CheckRedirect = func(*http.Request, []*http.Request) error {
+		return http.ErrUseLastResponse
+	}
+	return &c
+}
+
+// clientRequest turns req, a server request as created by httpRequest, into a
+// request that can be sent to srv. The Host header is left intact.
+func clientRequest(req *http.Request, srv *httptest.Server) *http.Request {
+	// Clone deeply, so that setting the URL's host leaves req intact.
+	out := req.Clone(context.Background())
+	u, _ := url.Parse(srv.URL)
+	out.URL.Scheme, out.URL.Host = u.Scheme, u.Host
+	// Clients must not set RequestURI.
+	out.RequestURI = ""
+	if len(out.Trailer) > 0 {
+		// Trailers are only sent with a chunked body, which requires the
+		// length of the body to be unknown.
+		out.ContentLength = -1
+		out.Body = ioutil.NopCloser(out.Body)
+	}
+	return out
+}
+
+// roundTrip sends req to srv, and records the response.
+func roundTrip(c *http.Client, srv *httptest.Server, req *http.Request) (*httptest.ResponseRecorder, error) {
+	res, err := c.Do(clientRequest(req, srv))
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		_ = res.Body.Close()
+	}()
+
+	rec := httptest.NewRecorder()
+	for k, v := range res.Header {
+		rec.Header()[k] = v
+	}
+	rec.WriteHeader(res.StatusCode)
+	if _, err := io.Copy(rec, res.Body); err != nil {
+		return nil, err
+	}
+	// Trailers are only known after the body has been read.
+	for k, v := range res.Trailer {
+		rec.Header()[http.TrailerPrefix+k] = v
+	}
+	return rec, nil
+}
+
+// AssertClientDisconnect fires req at a live server serving h, and closes
+// the connection as soon as h starts handling it, without reading the
+// response. It flags t as failed if h does not return within timeout of the
+// disconnect, as that means h does not observe r.Context().Done().
+//
+// A handler that does not return is abandoned, so that the server can shut
+// down: it must not use the response after AssertClientDisconnect returned.
+func AssertClientDisconnect(t tt, h http.Handler, req Request, timeout time.Duration) {
+	started := make(chan struct{})
+	done := make(chan error, 1)
+	abandon, cancelAbandon := context.WithCancel(context.Background())
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		close(started)
+		returned := make(chan struct{})
+		go func() {
+			defer close(returned)
+			h.ServeHTTP(w, r)
+			done <- r.Context().Err()
+		}()
+		select {
+		case <-returned:
+		case <-abandon.Done():
+		}
+	}))
+	defer srv.Close()
+	// Runs before the server is closed, which waits for the handler.
+	defer cancelAbandon()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	creq := clientRequest(httpRequest(&req), srv).WithContext(ctx)
+	go func() {
+		if res, err := newClient(srv).Do(creq); err == nil {
+			_ = res.Body.Close()
+		}
+	}()
+
+	select {
+	case <-started:
+	case <-time.After(timeout):
+		t.Errorf("Handler was not called within %s", timeout)
+		return
+	}
+	cancel()
+
+	select {
+	case err := <-done:
+		if err == nil {
+			t.Errorf("Handler returned before the client disconnected")
+		}
+	case <-time.After(timeout):
+		t.Errorf("Handler did not return within %s of the client disconnecting", timeout)
+	}
+}
+
+// AssertRejectsConflictingFraming writes a request for method and url with
+// both a Content-Length and a Transfer-Encoding: chunked header over a raw
+// connection to a live server serving h, and flags t as failed if the
+// response is not 400 Bad Request. Such a request is ambiguous about where its
+// body ends, which is the basis of request smuggling between proxies and
+// servers that disagree on it.
+//
+// As net/http itself lets Transfer-Encoding override Content-Length, and
+// removes the latter before calling the handler, h must reject the request
+// itself, e.g. through middleware that refuses chunked request bodies. The
+// request cannot be crafted with ServeHTTP, so this always uses a live server.
+func AssertRejectsConflictingFraming(t tt, h http.Handler, method, url string) {
+	srv := httptest.NewServer(h)
+	defer srv.Close()
+
+	conn, err := net.DialTimeout("tcp", srv.Listener.Addr().String(), 5*time.Second)
+	if err != nil {
+		t.Errorf("Cannot connect to server: %s", err)
+		return
+	}
+	defer func() {
+		_ = conn.Close()
+	}()
+	_ = conn.SetDeadline(time.Now().Add(5 * time.Second))
+
+	_, err = fmt.Fprintf(conn, "%s %s HTTP/1.1\r\n"+
+		"Host: %s\r\n"+
+		"Content-Length: 4\r\n"+
+		"Transfer-Encoding: chunked\r\n"+
+		"Connection: close\r\n"+
+		"\r\n"+
+		"4\r\nbody\r\n0\r\n\r\n", method, url, srv.Listener.Addr())
+	if err != nil {
+		t.Errorf("Cannot write request: %s", err)
+		return
+	}
+	res, err := http.ReadResponse(bufio.NewReader(conn), nil)
+	if err != nil {
+		t.Errorf("Cannot read response: %s", err)
+		return
+	}
+	_ = res.Body.Close()
+	if res.StatusCode != http.StatusBadRequest {
+		t.Errorf("Got status code %d for %s %s with both Content-Length and Transfer-Encoding: chunked, expected %d", res.StatusCode, method, url, http.StatusBadRequest)
+	}
+}
@@ -0,0 +1,242 @@
+package handlertest
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestRunServer(t *testing.T) {
+	h := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/redirect" {
+			http.Redirect(w, r, "/foo", http.StatusFound)
+			return
+		}
+		b, _ := ioutil.ReadAll(r.Body)
+		w.Header().Set("X-Host", r.Host)
+		w.Header().Set("X-Accept-Encoding", r.Header.Get("Accept-Encoding"))
+		w.WriteHeader(http.StatusCreated)
+		_, _ = w.Write(append([]byte(r.Method+" "+r.URL.RequestURI()+" "), b...))
+	})
+
+	var m mock
+	RunServer(&m, h, TestCase{
+		Request: Request{
+			Method: http.MethodPut,
+			URL:    "/foo?bar=baz",
+			Body:   "Hello world!",
+		},
+		Response: Response{
+			Code:    http.StatusCreated,
+			Body:    "PUT /foo?bar=baz Hello world!",
+			Headers: []string{"X-Host: example.com", "X-Accept-Encoding: "},
+		},
+	}, TestCase{
+		Request: Request{Method: http.MethodGet, URL: "/redirect"},
+		Response: Response{
+			Code:    http.StatusFound,
+			Headers: []string{"Location: /foo"},
+		},
+	})
+
+	if m.errored {
+		t.Errorf("Got %q, expected no errors", m.errors)
+	}
+}
+
+func TestAssertClientDisconnect(t *testing.T) {
+	req := Request{Method: http.MethodGet, URL: "/poll"}
+
+	t.Run("Handler observes cancellation", func(t *testing.T) {
+		h := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			<-r.Context().Done()
+		})
+
+		var m mock
+		AssertClientDisconnect(&m, h, req, time.Second)
+		if m.errored {
+			t.Errorf("Got %q, expected no errors", m.errors)
+		}
+	})
+
+	t.Run("Handler ignores cancellation", func(t *testing.T) {
+		release := make(chan struct{})
+		defer close(release)
+		h := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			<-release
+		})
+
+		var m mock
+		AssertClientDisconnect(&m, h, req, 50*time.Millisecond)
+		if !m.errored {
+			t.Errorf("Got false, expected true")
+		}
+	})
+
+	t.Run("Handler returns immediately", func(t *testing.T) {
+		var m mock
+		AssertClientDisconnect(&m, emptyHandler, req, time.Second)
+		if !m.errored {
+			t.Errorf("Got false, expected true")
+		}
+	})
+}
+
+func TestClientRequest(t *testing.T) {
+	srv := httptest.NewServer(emptyHandler)
+	defer srv.Close()
+
+	req := httpRequest(&Request{Method: http.MethodGet, URL: "/foo"})
+	out := clientRequest(req, srv)
+	if out.URL.String() != srv.URL+"/foo" {
+		t.Errorf("Got %q, expected %q", out.URL, srv.URL+"/foo")
+	}
+	if req.URL.String() != "/foo" {
+		t.Errorf("Got %q, expected the request's URL to be left intact", req.URL)
+	}
+}
+
+func TestRequestTrailers(t *testing.T) {
+	h := func(consume bool) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if consume {
+				_, _ = ioutil.ReadAll(r.Body)
+			}
+		})
+	}
+	tc := TestCase{
+		Request: Request{
+			Method:          http.MethodPost,
+			URL:             "/upload",
+			Body:            "Hello world!",
+			RequestTrailers: map[string]string{"X-Checksum": "abc"},
+		},
+		Response: Response{
+			ExpectHandlerSawTrailers: map[string]string{"x-checksum": "abc"},
+		},
+	}
+
+	tt := []struct {
+		name string
+
+		h  http.Handler
+		tc TestCase
+
+		expectError bool
+	}{
+		{
+			name: "Consumes body",
+			h:    h(true),
+			tc:   tc,
+		},
+		{
+			name:        "Ignores body",
+			h:           h(false),
+			tc:          tc,
+			expectError: true,
+		},
+		{
+			name: "Different value",
+			h:    h(true),
+			tc: TestCase{
+				Request: tc.Request,
+				Response: Response{
+					ExpectHandlerSawTrailers: map[string]string{"X-Checksum": "def"},
+				},
+			},
+			expectError: true,
+		},
+	}
+	for _, tc := range tt {
+		t.Run(tc.name, func(t *testing.T) {
+			var m mock
+			RunServer(&m, tc.h, tc.tc)
+			if m.errored != tc.expectError {
+				t.Errorf("Got %t (%q), expected %t", m.errored, m.errors, tc.expectError)
+			}
+		})
+	}
+}
+
+func TestRunServerRejectedRequest(t *testing.T) {
+	h := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {})
+	tcs := []TestCase{
+		{
+			Request:  Request{Method: http.MethodGet, URL: "/?id=1"},
+			Response: Response{ExpectQuery: url.Values{"id": {"1"}}},
+		},
+		{
+			// Exceeds the server's limit on the size of headers, so the
+			// handler is never called.
+			Request: Request{
+				Method:  http.MethodGet,
+				URL:     "/?id=2",
+				Headers: []string{"X-Large: " + strings.Repeat("a", 2*http.DefaultMaxHeaderBytes)},
+			},
+			Response: Response{
+				Code:        http.StatusRequestHeaderFieldsTooLarge,
+				ExpectQuery: url.Values{"id": {"2"}},
+			},
+		},
+	}
+
+	var m mock
+	RunServer(&m, h, tcs...)
+	expectErrors := []string{"Cannot assert query parameters: handler was not called"}
+	if !reflect.DeepEqual(m.errors, expectErrors) {
+		t.Errorf("Got %q, expected %q", m.errors, expectErrors)
+	}
+}
+
+func TestRequestTrailersWithoutServer(t *testing.T) {
+	req := httpRequest(&Request{
+		Method:          http.MethodPost,
+		URL:             "/upload",
+		RequestTrailers: map[string]string{"x-checksum": "abc"},
+	})
+	if v := req.Trailer.Get("X-Checksum"); v != "abc" {
+		t.Errorf("Got %q, expected abc", v)
+	}
+}
+
+func TestAssertRejectsConflictingFraming(t *testing.T) {
+	strict := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if len(r.TransferEncoding) > 0 {
+			http.Error(w, "chunked request bodies are not supported", http.StatusBadRequest)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	})
+
+	tt := []struct {
+		name string
+
+		h http.Handler
+
+		expectErrors []string
+	}{
+		{
+			name: "Rejected",
+			h:    strict,
+		},
+		{
+			name:         "Accepted",
+			h:            emptyHandler,
+			expectErrors: []string{"Got status code 200 for POST /upload with both Content-Length and Transfer-Encoding: chunked, expected 400"},
+		},
+	}
+	for _, tc := range tt {
+		t.Run(tc.name, func(t *testing.T) {
+			var m mock
+			AssertRejectsConflictingFraming(&m, tc.h, http.MethodPost, "/upload")
+			if !reflect.DeepEqual(m.errors, tc.expectErrors) {
+				t.Errorf("Got %q, expected %q", m.errors, tc.expectErrors)
+			}
+		})
+	}
+}
@@ -0,0 +1,79 @@
+package handlertest
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+)
+
+// JSONArrayStream describes an expected JSON array response body, of which the
+// elements are decoded and checked one at a time, rather than decoding the
+// entire array at once. This keeps memory bounded for large collections.
+// Fields that are not set are not asserted.
+type JSONArrayStream struct {
+	// Count is the expected number of elements.
+	Count *int
+	// Each is an object every element must contain, as in BodyJSONArray.
+	// Fields that are not expected are ignored.
+	Each map[string]interface{}
+	// Check is called with every decoded element, and returns an error if
+	// the element is invalid. It can only be set from code.
+	Check func(elem interface{}) error `yaml:"-"`
+}
+
+func assertJSONArrayStream(t tt, ex *exchange, res *Response) {
+	s := res.JSONArrayStream
+	if s == nil {
+		return
+	}
+	var each interface{}
+	if s.Each != nil {
+		var err error
+		if each, err = normalizeJSON(s.Each); err != nil {
+			t.Errorf("Invalid expected JSON array element: %s", err)
+			return
+		}
+	}
+
+	dec := json.NewDecoder(bytes.NewReader(ex.body))
+	if tok, err := dec.Token(); err != nil || tok != json.Delim('[') {
+		t.Errorf("Got response body that does not start with a JSON array: %s", tokenErr(tok, err))
+		return
+	}
+	d := jsonDiffer{subset: true}
+	var n int
+	for ; dec.More(); n++ {
+		var elem interface{}
+		if err := dec.Decode(&elem); err != nil {
+			t.Errorf("Got invalid JSON array element at index %d: %s", n, err)
+			return
+		}
+		if each != nil {
+			if diffs := d.diff(each, elem, []interface{}{n}); len(diffs) > 0 {
+				t.Errorf("Got unexpected JSON array element at index %d: %s", n, diffs[0])
+				return
+			}
+		}
+		if s.Check != nil {
+			if err := s.Check(elem); err != nil {
+				t.Errorf("Got invalid JSON array element at index %d: %s", n, err)
+				return
+			}
+		}
+	}
+	if tok, err := dec.Token(); err != nil || tok != json.Delim(']') {
+		t.Errorf("Got response body with unterminated JSON array after %d elements: %s", n, tokenErr(tok, err))
+		return
+	}
+	if s.Count != nil && n != *s.Count {
+		t.Errorf("Got JSON array of %d elements, expected %d", n, *s.Count)
+	}
+}
+
+// tokenErr describes err, or the unexpected token tok if err is nil.
+func tokenErr(tok json.Token, err error) string {
+	if err != nil {
+		return err.Error()
+	}
+	return fmt.Sprintf("unexpected %v", tok)
+}
@@ -0,0 +1,71 @@
+package handlertest
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+)
+
+func TestAssertJSONArrayStream(t *testing.T) {
+	three := 3
+	positive := func(elem interface{}) error {
+		if id, _ := elem.(map[string]interface{})["id"].(float64); id <= 0 {
+			return errors.New("id is not positive")
+		}
+		return nil
+	}
+
+	tt := []struct {
+		name string
+
+		body string
+		exp  *JSONArrayStream
+
+		expectErrors []string
+	}{
+		{
+			name: "Matching",
+			body: `[{"id": 1, "type": "item"}, {"id": 2, "type": "item"}, {"id": 3, "type": "item"}]`,
+			exp:  &JSONArrayStream{Count: &three, Each: map[string]interface{}{"type": "item"}, Check: positive},
+		},
+		{
+			name:         "Wrong count",
+			body:         `[{"id": 1}, {"id": 2}]`,
+			exp:          &JSONArrayStream{Count: &three},
+			expectErrors: []string{"Got JSON array of 2 elements, expected 3"},
+		},
+		{
+			name:         "Element not containing expected",
+			body:         `[{"id": 1, "type": "item"}, {"id": 2, "type": "folder"}, {"id": 3}]`,
+			exp:          &JSONArrayStream{Each: map[string]interface{}{"type": "item"}},
+			expectErrors: []string{`Got unexpected JSON array element at index 1: $[1].type: got "folder", expected "item"`},
+		},
+		{
+			name:         "Element failing check",
+			body:         `[{"id": 1}, {"id": 0}]`,
+			exp:          &JSONArrayStream{Check: positive},
+			expectErrors: []string{"Got invalid JSON array element at index 1: id is not positive"},
+		},
+		{
+			name:         "Not an array",
+			body:         `{"items": []}`,
+			exp:          &JSONArrayStream{},
+			expectErrors: []string{"Got response body that does not start with a JSON array: unexpected {"},
+		},
+		{
+			name:         "Truncated",
+			body:         `[{"id": 1}, {"id": 2}`,
+			exp:          &JSONArrayStream{Count: &three},
+			expectErrors: []string{"Got invalid JSON array element at index 2: unexpected end of JSON input"},
+		},
+	}
+	for _, tc := range tt {
+		t.Run(tc.name, func(t *testing.T) {
+			var m mock
+			assertJSONArrayStream(&m, &exchange{body: []byte(tc.body)}, &Response{JSONArrayStream: tc.exp})
+			if !reflect.DeepEqual(m.errors, tc.expectErrors) {
+				t.Errorf("Got %q, expected %q", m.errors, tc.expectErrors)
+			}
+		})
+	}
+}
@@ -0,0 +1,121 @@
+package handlertest
+
+import (
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"sort"
+	"strings"
+)
+
+var unmarshalerType = reflect.TypeOf((*json.Unmarshaler)(nil)).Elem()
+
+func assertExpectStruct(t tt, ex *exchange, res *Response) {
+	if res.ExpectStruct == nil {
+		return
+	}
+	exp := reflect.Indirect(reflect.ValueOf(res.ExpectStruct))
+	if exp.Kind() != reflect.Struct {
+		t.Errorf("Invalid ExpectStruct of type %T, expected a struct", res.ExpectStruct)
+		return
+	}
+	act := reflect.New(exp.Type())
+	if err := json.Unmarshal(ex.body, act.Interface()); err != nil {
+		t.Errorf("Cannot decode response body into %s: %s", exp.Type(), err)
+		return
+	}
+
+	for _, path := range res.RequiredFields {
+		v, err := structField(act.Elem(), path)
+		if err != nil {
+			t.Errorf("Invalid required field %q: %s", path, err)
+			continue
+		}
+		if !v.IsValid() || v.IsZero() {
+			t.Errorf("Missing required field %q in response body %s", path, string(ex.body))
+		}
+	}
+	for _, msg := range diffStruct(exp, act.Elem(), "") {
+		t.Errorf("Got response body with %s", msg)
+	}
+}
+
+// structField returns the field of v at path, a dot-separated list of JSON
+// field names, such as "data.id". If path traverses a nil pointer, the zero
+// Value is returned.
+func structField(v reflect.Value, path string) (reflect.Value, error) {
+	for _, name := range strings.Split(path, ".") {
+		v = reflect.Indirect(v)
+		if !v.IsValid() {
+			return v, nil
+		}
+		if v.Kind() != reflect.Struct {
+			return reflect.Value{}, fmt.Errorf("%s is not a struct", v.Type())
+		}
+		i, ok := jsonFields(v.Type())[name]
+		if !ok {
+			return reflect.Value{}, fmt.Errorf("%s has no field %q", v.Type(), name)
+		}
+		v = v.FieldByIndex(i)
+	}
+	return v, nil
+}
+
+// diffStruct compares the non-zero fields of exp, which are pinned, to those
+// of act, and returns a message for every field that differs. Nested structs
+// are compared field by field, unless they decode themselves from JSON.
+func diffStruct(exp, act reflect.Value, prefix string) []string {
+	fields := jsonFields(exp.Type())
+	names := make([]string, 0, len(fields))
+	for name := range fields {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var msgs []string
+	for _, name := range names {
+		ev, av := exp.FieldByIndex(fields[name]), act.FieldByIndex(fields[name])
+		if ev.IsZero() {
+			continue
+		}
+		path := prefix + name
+		if ev.Kind() == reflect.Struct && !reflect.PtrTo(ev.Type()).Implements(unmarshalerType) {
+			msgs = append(msgs, diffStruct(ev, av, path+".")...)
+			continue
+		}
+		if !reflect.DeepEqual(ev.Interface(), av.Interface()) {
+			msgs = append(msgs, fmt.Sprintf("field %q %v, expected %v", path, reflect.Indirect(av), reflect.Indirect(ev)))
+		}
+	}
+	return msgs
+}
+
+// jsonFields maps the JSON names of the exported fields of typ, a struct type,
+// to their indices, as encoding/json would. Fields of embedded structs
+// without a JSON name are promoted.
+func jsonFields(typ reflect.Type) map[string][]int {
+	fields := make(map[string][]int)
+	for i := 0; i < typ.NumField(); i++ {
+		f := typ.Field(i)
+		tag := strings.Split(f.Tag.Get("json"), ",")[0]
+		if tag == "-" {
+			continue
+		}
+		if f.Anonymous && tag == "" && f.Type.Kind() == reflect.Struct {
+			for name, index := range jsonFields(f.Type) {
+				if _, ok := fields[name]; !ok {
+					fields[name] = append([]int{i}, index...)
+				}
+			}
+			continue
+		}
+		if f.PkgPath != "" {
+			continue
+		}
+		if tag == "" {
+			tag = f.Name
+		}
+		fields[tag] = []int{i}
+	}
+	return fields
+}
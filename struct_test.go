@@ -0,0 +1,103 @@
+package handlertest
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+)
+
+func TestAssertExpectStruct(t *testing.T) {
+	type meta struct {
+		Version int `json:"version"`
+	}
+	type user struct {
+		meta
+		ID     string  `json:"id"`
+		Name   string  `json:"name"`
+		Admin  bool    `json:"is_admin"`
+		Email  *string `json:"email,omitempty"`
+		Secret string  `json:"-"`
+		Team   struct {
+			Name string `json:"name"`
+		} `json:"team"`
+	}
+	body := `{"id": "42", "name": "Alice", "is_admin": true, "version": 3, "team": {"name": "core"}, "extra": 1}`
+	pinned := user{Name: "Alice", Admin: true}
+	pinned.Team.Name = "core"
+
+	tt := []struct {
+		name string
+
+		body     string
+		exp      interface{}
+		required []string
+
+		expectErrors []string
+	}{
+		{
+			name:     "Zero value with required fields",
+			body:     body,
+			exp:      user{},
+			required: []string{"id", "version", "team.name"},
+		},
+		{
+			name: "Pinned fields",
+			body: body,
+			exp:  &pinned,
+		},
+		{
+			name:     "Missing required fields",
+			body:     `{"name": "Alice"}`,
+			exp:      user{},
+			required: []string{"id", "email", "team.name"},
+			expectErrors: []string{
+				`Missing required field "id" in response body {"name": "Alice"}`,
+				`Missing required field "email" in response body {"name": "Alice"}`,
+				`Missing required field "team.name" in response body {"name": "Alice"}`,
+			},
+		},
+		{
+			name: "Pinned fields differ",
+			body: `{"name": "Bob", "is_admin": true, "team": {"name": "ops"}}`,
+			exp:  pinned,
+			expectErrors: []string{
+				`Got response body with field "name" Bob, expected Alice`,
+				`Got response body with field "team.name" ops, expected core`,
+			},
+		},
+		{
+			name:         "Unknown required field",
+			body:         body,
+			exp:          user{},
+			required:     []string{"Secret"},
+			expectErrors: []string{`Invalid required field "Secret": handlertest.user has no field "Secret"`},
+		},
+		{
+			name:         "Invalid JSON",
+			body:         "nope",
+			exp:          user{},
+			expectErrors: []string{"Cannot decode response body into handlertest.user: invalid character 'o' in literal null (expecting 'u')"},
+		},
+		{
+			name:         "Not a struct",
+			body:         body,
+			exp:          map[string]interface{}{},
+			expectErrors: []string{"Invalid ExpectStruct of type map[string]interface {}, expected a struct"},
+		},
+	}
+	for _, tc := range tt {
+		t.Run(tc.name, func(t *testing.T) {
+			var m mock
+			ex := &exchange{
+				rec:  &httptest.ResponseRecorder{Code: http.StatusOK, Body: bytes.NewBufferString(tc.body)},
+				body: []byte(tc.body),
+			}
+			assertExpectStruct(&m, ex, &Response{ExpectStruct: tc.exp, RequiredFields: tc.required})
+			if !reflect.DeepEqual(m.errors, tc.expectErrors) {
+				t.Errorf("Got %q, expected %q", m.errors, tc.expectErrors)
+			}
+		})
+	}
+}
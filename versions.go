@@ -0,0 +1,67 @@
+package handlertest
+
+import (
+	"net/http"
+	"sort"
+)
+
+// WithVersionFunc makes the Runner call f to direct a request at an API
+// version, for test cases with Versions. By default, the URL path is prefixed
+// with the version, so `/users` becomes `/v2/users` for version "v2". To
+// select a version through a header instead, f could set e.g. Accept.
+func WithVersionFunc(f func(r *http.Request, version string)) Option {
+	return func(r *Runner) {
+		r.versionFunc = f
+	}
+}
+
+// directVersion directs req at version, as configured by WithVersionFunc.
+func (r *Runner) directVersion(req *http.Request, version string) {
+	if r.versionFunc != nil {
+		r.versionFunc(req, version)
+		return
+	}
+	req.URL.Path = "/" + version + req.URL.Path
+	if req.URL.RawPath != "" {
+		req.URL.RawPath = "/" + version + req.URL.RawPath
+	}
+	req.RequestURI = req.URL.RequestURI()
+}
+
+// expandVersions replaces every test case with Versions by a test case for
+// each version, sorted by version.
+func expandVersions(tcs []TestCase) []TestCase {
+	var expanded []TestCase
+	for i, tc := range tcs {
+		if len(tc.Versions) == 0 {
+			if expanded != nil {
+				expanded = append(expanded, tc)
+			}
+			continue
+		}
+		if expanded == nil {
+			expanded = append([]TestCase(nil), tcs[:i]...)
+		}
+
+		versions := make([]string, 0, len(tc.Versions))
+		for v := range tc.Versions {
+			versions = append(versions, v)
+		}
+		sort.Strings(versions)
+		for _, v := range versions {
+			vtc := tc
+			vtc.Versions = nil
+			vtc.version = v
+			mergeResponse(&vtc.Response, tc.Versions[v])
+			vtc.Name = v
+			if tc.Name != "" {
+				vtc.Name = tc.Name + " (" + v + ")"
+			}
+			expanded = append(expanded, vtc)
+		}
+	}
+	if expanded == nil {
+		return tcs
+	}
+	return expanded
+}
@@ -0,0 +1,99 @@
+package handlertest
+
+import (
+	"net/http"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestVersions(t *testing.T) {
+	h := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		version := r.Header.Get("Accept-Version")
+		if version == "" {
+			version = strings.Split(r.URL.Path, "/")[1]
+		}
+		switch version {
+		case "v1":
+			_, _ = w.Write([]byte(`{"name": "Alice Smith"}`))
+		case "v2":
+			_, _ = w.Write([]byte(`{"first": "Alice", "last": "Smith"}`))
+		default:
+			http.NotFound(w, r)
+		}
+	})
+	tc := TestCase{
+		Name:    "Get user",
+		Request: Request{Method: http.MethodGet, URL: "/users/1"},
+		Versions: map[string]Response{
+			"v2": {Body: `{"first": "Alice", "last": "Smith"}`},
+			"v1": {Body: `{"name": "Alice Smith"}`},
+			"v3": {Code: http.StatusNotFound},
+		},
+	}
+
+	t.Run("Path prefix", func(t *testing.T) {
+		var names []string
+		m := mock{runFunc: func(name string, f func(t *testing.T)) bool {
+			names = append(names, name)
+			return t.Run(name, f)
+		}}
+		Run(&m, h, tc)
+
+		exp := []string{"Get user (v1)", "Get user (v2)", "Get user (v3)"}
+		if !reflect.DeepEqual(names, exp) {
+			t.Errorf("Got %q, expected %q", names, exp)
+		}
+	})
+
+	t.Run("Version func", func(t *testing.T) {
+		var m mock
+		m.runFunc = t.Run
+		New(WithVersionFunc(func(r *http.Request, version string) {
+			r.Header.Set("Accept-Version", version)
+		})).Run(&m, h, tc)
+	})
+
+	t.Run("Unnamed", func(t *testing.T) {
+		var names []string
+		m := mock{runFunc: func(name string, f func(t *testing.T)) bool {
+			names = append(names, name)
+			return true
+		}}
+		tc := tc
+		tc.Name = ""
+		tc.Versions = map[string]Response{"v1": {Body: "foo"}}
+		Run(&m, h, tc)
+		if exp := []string{"v1"}; !reflect.DeepEqual(names, exp) {
+			t.Errorf("Got %q, expected %q", names, exp)
+		}
+	})
+}
+
+func TestExpandVersions(t *testing.T) {
+	plain := TestCase{Name: "plain"}
+	versioned := TestCase{
+		Response: Response{Code: http.StatusOK, Body: "base"},
+		Versions: map[string]Response{"v1": {Body: "one"}, "v2": {}},
+	}
+
+	got := expandVersions([]TestCase{plain, versioned, plain})
+	if len(got) != 4 {
+		t.Fatalf("Got %d, expected 4", len(got))
+	}
+	for i, exp := range []struct {
+		name, version, body string
+	}{
+		{"plain", "", ""},
+		{"v1", "v1", "one"},
+		{"v2", "v2", "base"},
+		{"plain", "", ""},
+	} {
+		if got[i].Name != exp.name || got[i].version != exp.version || got[i].Response.Body != exp.body {
+			t.Errorf("Got %q, %q and %q, expected %q, %q and %q", got[i].Name, got[i].version, got[i].Response.Body, exp.name, exp.version, exp.body)
+		}
+		if got[i].Versions != nil {
+			t.Errorf("Got %v, expected nil", got[i].Versions)
+		}
+	}
+}
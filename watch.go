@@ -0,0 +1,128 @@
+package handlertest
+
+import (
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+
+	"gopkg.in/yaml.v2"
+)
+
+// WatchEnv is the environment variable that enables Watch.
+const WatchEnv = "HANDLERTEST_WATCH"
+
+// watchInterval is how often Watch polls for changed fixtures.
+var watchInterval = 500 * time.Millisecond
+
+// Watch runs the test cases of every YAML fixture in dir against h, and then
+// keeps polling dir, running fixtures again as they are added or modified. It
+// does not return. Results are written to standard error as they come in,
+// rather than flagging t as failed, so a broken fixture does not end the
+// session. They are not logged to t, as go test -v holds back logs until the
+// test ends before Go 1.14.
+//
+// Watch is a convenience for editing fixtures during development, and is not
+// meant for normal go test runs: unless the HANDLERTEST_WATCH environment
+// variable is set, it logs a message and returns immediately. Run it with e.g.
+//
+//	HANDLERTEST_WATCH=1 go test -v -run TestWatch -timeout 0
+func Watch(t tt, h http.Handler, dir string) {
+	New().Watch(t, h, dir)
+}
+
+// Watch is like the package-level Watch, but with the Runner's options
+// applied.
+func (r *Runner) Watch(t tt, h http.Handler, dir string) {
+	if os.Getenv(WatchEnv) == "" {
+		t.Logf("handlertest: not watching %s, set %s to enable", dir, WatchEnv)
+		return
+	}
+	r.watch(writerT{tt: t, w: os.Stderr}, h, dir, watchInterval, nil)
+}
+
+// watch implements Watch, polling every interval until stop is closed.
+// Results are reported on t, which is expected to log failures.
+func (r *Runner) watch(t tt, h http.Handler, dir string, interval time.Duration, stop <-chan struct{}) {
+	modified := make(map[string]time.Time)
+	for {
+		for _, p := range fixtures(dir) {
+			fi, err := os.Stat(p)
+			if err != nil || fi.ModTime().Equal(modified[p]) {
+				continue
+			}
+			modified[p] = fi.ModTime()
+			r.watchRun(t, h, p)
+		}
+
+		select {
+		case <-stop:
+			return
+		case <-time.After(interval):
+		}
+	}
+}
+
+// fixtures returns the paths of the YAML files in dir, sorted.
+func fixtures(dir string) []string {
+	var paths []string
+	for _, pattern := range []string{"*.yaml", "*.yml"} {
+		matches, _ := filepath.Glob(filepath.Join(dir, pattern))
+		paths = append(paths, matches...)
+	}
+	sort.Strings(paths)
+	return paths
+}
+
+// watchRun runs the test cases in the YAML file at path against h, and
+// reports their failures and a summary on t, which is expected to log
+// failures.
+func (r *Runner) watchRun(t tt, h http.Handler, path string) {
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Errorf("%s: io/ioutil: ReadFile: %s", path, err)
+		return
+	}
+	var tcs []TestCase
+	if err := yaml.Unmarshal(b, &tcs); err != nil {
+		t.Errorf("%s: yaml: Unmarshal: %s", path, err)
+		return
+	}
+	resolvePaths(tcs, filepath.Dir(path))
+
+	s := r.newSession(h)
+	defer s.close()
+
+	var failed int
+	for i := range tcs {
+		ft := &failureT{tt: prefixT{tt: t, prefix: path + ": " + caseName(i, &tcs[i]) + ": "}}
+		s.run(ft, tcs[i])
+		if ft.failed {
+			failed++
+		}
+	}
+	t.Logf("handlertest: %s: %d passed, %d failed", path, len(tcs)-failed, failed)
+}
+
+// writerT is a tt that writes failures and logs to w instead of reporting
+// these.
+type writerT struct {
+	tt
+	w io.Writer
+}
+
+func (t writerT) Errorf(format string, args ...interface{}) {
+	t.Logf(format, args...)
+}
+
+func (t writerT) Fatalf(format string, args ...interface{}) {
+	t.Logf(format, args...)
+}
+
+func (t writerT) Logf(format string, args ...interface{}) {
+	fmt.Fprintf(t.w, format+"\n", args...)
+}
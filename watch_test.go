@@ -0,0 +1,107 @@
+package handlertest
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestWatch(t *testing.T) {
+	t.Run("Disabled", func(t *testing.T) {
+		if os.Getenv(WatchEnv) != "" {
+			t.Skipf("%s is set", WatchEnv)
+		}
+
+		var m mock
+		Watch(&m, emptyHandler, "testdata")
+		if len(m.logs) != 1 || !strings.Contains(m.logs[0], "not watching") {
+			t.Errorf("Got %q, expected a single not watching message", m.logs)
+		}
+	})
+
+	t.Run("Runs fixtures", func(t *testing.T) {
+		dir, err := ioutil.TempDir("", "handlertest")
+		if err != nil {
+			t.Fatalf("Got %s, expected nil", err)
+		}
+		defer os.RemoveAll(dir)
+		fixtures := map[string]string{
+			"ok.yaml":     "- GET /foo => 200\n",
+			"failed.yml":  "- GET /foo => 404\n",
+			"invalid.yml": "- {",
+			"ignored.txt": "- GET /foo => 404\n",
+		}
+		for name, content := range fixtures {
+			if err := ioutil.WriteFile(filepath.Join(dir, name), []byte(content), 0644); err != nil {
+				t.Fatalf("Got %s, expected nil", err)
+			}
+		}
+
+		stop := make(chan struct{})
+		close(stop)
+		var m mock
+		var buf bytes.Buffer
+		New().watch(writerT{tt: &m, w: &buf}, emptyHandler, dir, time.Millisecond, stop)
+
+		if m.errored || m.fataled || len(m.logs) > 0 {
+			t.Errorf("Got %q and %q, expected results to be written only", m.errors, m.logs)
+		}
+		lines := strings.Split(strings.TrimSuffix(buf.String(), "\n"), "\n")
+		var summaries []string
+		for _, l := range lines {
+			if strings.HasPrefix(l, "handlertest: ") {
+				summaries = append(summaries, strings.TrimPrefix(l, "handlertest: "+dir+string(filepath.Separator)))
+			}
+		}
+		expected := []string{"failed.yml: 0 passed, 1 failed", "ok.yaml: 1 passed, 0 failed"}
+		if !reflect.DeepEqual(summaries, expected) {
+			t.Errorf("Got %q, expected %q", summaries, expected)
+		}
+		if len(lines) != 4 {
+			t.Errorf("Got %q, expected 2 failures and 2 summaries", lines)
+		}
+	})
+
+	t.Run("Applies options", func(t *testing.T) {
+		dir, err := ioutil.TempDir("", "handlertest")
+		if err != nil {
+			t.Fatalf("Got %s, expected nil", err)
+		}
+		defer os.RemoveAll(dir)
+		if err := ioutil.WriteFile(filepath.Join(dir, "ok.yaml"), []byte("- GET /foo => 200 ignored\n"), 0644); err != nil {
+			t.Fatalf("Got %s, expected nil", err)
+		}
+
+		stop := make(chan struct{})
+		close(stop)
+		var m mock
+		var buf bytes.Buffer
+		ignore := WithBodyIgnore(func(b []byte) []byte { return []byte("ignored") })
+		New(ignore).watch(writerT{tt: &m, w: &buf}, emptyHandler, dir, time.Millisecond, stop)
+
+		if !strings.HasSuffix(buf.String(), "ok.yaml: 1 passed, 0 failed\n") {
+			t.Errorf("Got %q, expected the fixture to pass", buf.String())
+		}
+	})
+}
+
+func TestWriterT(t *testing.T) {
+	var m mock
+	var buf bytes.Buffer
+	wt := writerT{tt: &m, w: &buf}
+	wt.Errorf("foo %d", 1)
+	wt.Fatalf("bar %d", 2)
+	wt.Logf("baz %d", 3)
+
+	if m.errored || m.fataled || len(m.logs) > 0 {
+		t.Errorf("Got %q and %q, expected nothing to be reported on t", m.errors, m.logs)
+	}
+	if expected := "foo 1\nbar 2\nbaz 3\n"; buf.String() != expected {
+		t.Errorf("Got %q, expected %q", buf.String(), expected)
+	}
+}
@@ -0,0 +1,117 @@
+package handlertest
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// errLateWrite is returned to handlers writing to a closed closingWriter.
+var errLateWrite = errors.New("handlertest: write after handler returned")
+
+// closingWriter wraps a ResponseWriter. Once closed, which happens when the
+// handler returns, it no longer passes writes on, but records them instead.
+type closingWriter struct {
+	http.ResponseWriter
+
+	mu     sync.Mutex
+	closed bool
+	late   []string
+	// wrote is closed upon the first late write.
+	wrote chan struct{}
+}
+
+func newClosingWriter(w http.ResponseWriter) *closingWriter {
+	return &closingWriter{
+		ResponseWriter: w,
+		wrote:          make(chan struct{}),
+	}
+}
+
+func (w *closingWriter) Write(b []byte) (int, error) {
+	if w.recordLate(fmt.Sprintf("Write of %d bytes %q", len(b), b)) {
+		return 0, errLateWrite
+	}
+	return w.ResponseWriter.Write(b)
+}
+
+func (w *closingWriter) WriteHeader(code int) {
+	if w.recordLate(fmt.Sprintf("WriteHeader(%d)", code)) {
+		return
+	}
+	w.ResponseWriter.WriteHeader(code)
+}
+
+func (w *closingWriter) Flush() {
+	if w.recordLate("Flush()") {
+		return
+	}
+	if f, ok := w.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+// recordLate records call if w is closed, and reports whether it was.
+func (w *closingWriter) recordLate(call string) bool {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if !w.closed {
+		return false
+	}
+	if len(w.late) == 0 {
+		close(w.wrote)
+	}
+	w.late = append(w.late, call)
+	return true
+}
+
+func (w *closingWriter) close() {
+	w.mu.Lock()
+	w.closed = true
+	w.mu.Unlock()
+}
+
+// assertNoLateWrites waits up to grace for a late write, and flags t as failed
+// for every late write that was made.
+func (w *closingWriter) assertNoLateWrites(t tt, grace time.Duration) {
+	select {
+	case <-w.wrote:
+	case <-time.After(grace):
+	}
+
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	for _, call := range w.late {
+		t.Errorf("Handler called %s after returning", call)
+	}
+}
+
+// bufferingWriter wraps a ResponseWriter, and counts the bytes written to it
+// since it was last flushed. It records the count at the first write that
+// takes it past max.
+type bufferingWriter struct {
+	http.ResponseWriter
+
+	max      int
+	buffered int
+	exceeded int
+}
+
+func (w *bufferingWriter) Write(b []byte) (int, error) {
+	n, err := w.ResponseWriter.Write(b)
+	w.buffered += n
+	if w.buffered > w.max && w.exceeded == 0 {
+		w.exceeded = w.buffered
+	}
+	return n, err
+}
+
+func (w *bufferingWriter) Flush() {
+	w.buffered = 0
+	if f, ok := w.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
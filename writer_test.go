@@ -0,0 +1,103 @@
+package handlertest
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestClosingWriter(t *testing.T) {
+	rec := httptest.NewRecorder()
+	w := newClosingWriter(rec)
+	if _, err := w.Write([]byte("foo")); err != nil {
+		t.Fatalf("Got %s, expected nil", err)
+	}
+	w.close()
+	if _, err := w.Write([]byte("bar")); err != errLateWrite {
+		t.Errorf("Got %v, expected %v", err, errLateWrite)
+	}
+	w.WriteHeader(http.StatusTeapot)
+
+	if s := rec.Body.String(); s != "foo" {
+		t.Errorf("Got %q, expected foo", s)
+	}
+	var m mock
+	w.assertNoLateWrites(&m, 0)
+	if len(m.errors) != 2 {
+		t.Errorf("Got %q, expected 2 errors", m.errors)
+	}
+}
+
+func TestWithLateWriteDetection(t *testing.T) {
+	tc := TestCase{Request: Request{Method: http.MethodGet, URL: "/"}}
+
+	t.Run("Late write", func(t *testing.T) {
+		h := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			go func() {
+				time.Sleep(10 * time.Millisecond)
+				_, _ = w.Write([]byte("Too late"))
+			}()
+		})
+
+		var m mock
+		New(WithLateWriteDetection(time.Second)).Run(&m, h, tc)
+		if !m.errored {
+			t.Errorf("Got false, expected true")
+		}
+	})
+
+	t.Run("No late write", func(t *testing.T) {
+		h := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			_, _ = w.Write([]byte("In time"))
+		})
+
+		var m mock
+		New(WithLateWriteDetection(10*time.Millisecond)).Run(&m, h, tc)
+		if m.errored {
+			t.Errorf("Got %q, expected no errors", m.errors)
+		}
+	})
+}
+
+func TestWithMaxBufferedBytes(t *testing.T) {
+	get := TestCase{Request: Request{Method: http.MethodGet, URL: "/"}}
+	h := func(flush bool) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			for i := 0; i < 4; i++ {
+				_, _ = w.Write([]byte("0123456789"))
+				if flush {
+					w.(http.Flusher).Flush()
+				}
+			}
+		})
+	}
+
+	tt := []struct {
+		name string
+
+		h http.Handler
+
+		expectErrors []string
+	}{
+		{
+			name: "Flushes",
+			h:    h(true),
+		},
+		{
+			name:         "Buffers",
+			h:            h(false),
+			expectErrors: []string{"Handler buffered 30 bytes without flushing, expected at most 25"},
+		},
+	}
+	for _, tc := range tt {
+		t.Run(tc.name, func(t *testing.T) {
+			var m mock
+			New(WithMaxBufferedBytes(25)).Run(&m, tc.h, get)
+			if !reflect.DeepEqual(m.errors, tc.expectErrors) {
+				t.Errorf("Got %q, expected %q", m.errors, tc.expectErrors)
+			}
+		})
+	}
+}